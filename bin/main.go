@@ -18,7 +18,6 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"github.com/bocon13/p4rt-go/p4rt"
@@ -39,11 +38,32 @@ func main() {
 	target := flag.String("target", "localhost:28000", "")
 	verbose := flag.Bool("verbose", false, "")
 	p4info := flag.String("p4info", "", "")
-	count := flag.Uint64("count", 1, "")
+	count := flag.Uint64("count", 1, "number of entries to write (at most 2^20, one per MPLS label)")
 	deviceConfig := flag.String("deviceConfig", "", "")
+	bundle := flag.String("bundle", "", "pipeline bundle to use instead of -p4info and -deviceConfig")
+	deviceConfigType := flag.String("deviceConfigType", "", "bmv2, tofino, tofino-bf or raw (default: detect from -deviceConfig)")
+	pipeconf := flag.String("pipeconf", "", "pipeconf name of Tofino device configs (default: "+p4rt.TOFINO_PIPECONF_NAME+")")
+	reconcile := flag.Bool("reconcile", false, "push the pipeline only if the switch isn't already running it")
+	pipelineAction := flag.String("pipelineAction", "VERIFY_AND_COMMIT",
+		"VERIFY, VERIFY_AND_SAVE, COMMIT, VERIFY_AND_COMMIT or RECONCILE_AND_COMMIT")
+	saveP4info := flag.String("saveP4info", "", "save the switch's P4Info to this file and exit")
+	saveDeviceConfig := flag.String("saveDeviceConfig", "", "save the switch's device config to this file and exit")
+	canonical := flag.Bool("canonical", false,
+		"send canonical bytestrings, as P4Runtime 1.2+ targets require (default off: older targets expect fixed-width values)")
 
 	flag.Parse()
 
+	if *count > 1<<20 {
+		fmt.Printf("-count must be at most %d (mpls_label is 20 bits)\n", 1<<20)
+		os.Exit(2)
+	}
+
+	action, ok := p4.SetForwardingPipelineConfigRequest_Action_value[*pipelineAction]
+	if !ok {
+		fmt.Printf("Unknown pipeline action: %s\n", *pipelineAction)
+		os.Exit(2)
+	}
+
 	client, err := p4rt.GetP4RuntimeClient(*target, 1)
 	if err != nil {
 		panic(err)
@@ -54,10 +74,62 @@ func main() {
 		panic(err)
 	}
 
-	err = client.SetForwardingPipelineConfig(*p4info, *deviceConfig)
-	if err != nil {
-		panic(err)
+	if *saveP4info != "" || *saveDeviceConfig != "" {
+		responseType := p4.GetForwardingPipelineConfigRequest_ALL
+		if *saveDeviceConfig == "" {
+			responseType = p4.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE
+		} else if *saveP4info == "" {
+			responseType = p4.GetForwardingPipelineConfigRequest_DEVICE_CONFIG_AND_COOKIE
+		}
+		config, err := client.GetForwardingPipelineConfig(p4rt.WithResponseType(responseType))
+		if err != nil {
+			panic(err)
+		}
+		err = p4rt.SavePipelineConfig(config, *saveP4info, *saveDeviceConfig)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Saved pipeline with cookie 0x%016x\n", config.GetCookie().GetCookie())
+		return
+	}
+
+	if *pipeconf != "" {
+		p4rt.TOFINO_PIPECONF_NAME = *pipeconf
+	}
+	client.SetTarget(*deviceConfigType)
+	if *bundle != "" {
+		*p4info, *deviceConfig = *bundle, ""
+	}
+	pipelineOpt := p4rt.WithAction(p4.SetForwardingPipelineConfigRequest_Action(action))
+	if action == int32(p4.SetForwardingPipelineConfigRequest_COMMIT) {
+		// commit the pipeline saved by an earlier VERIFY_AND_SAVE
+		result, err := client.CommitForwardingPipelineConfig()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Pipeline: %v\n", result)
+	} else if *reconcile {
+		result, err := client.ReconcileForwardingPipelineConfig(*p4info, *deviceConfig, pipelineOpt)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Reconcile: %v\n", result)
+		if result.Pushed && !result.Pipeline.Committed {
+			// the switch is still running its previous pipeline, so there is nothing to test
+			return
+		}
+	} else {
+		result, err := client.PushForwardingPipelineConfig(*p4info, *deviceConfig, pipelineOpt)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Pipeline: %v\n", result)
+		if !result.Committed {
+			// the switch is still running its previous pipeline, so there is nothing to test
+			return
+		}
 	}
+	client.SetCanonicalBytestrings(*canonical)
 
 	//config, err := client.GetForwardingPipelineConfig()
 	//if err != nil {
@@ -93,7 +165,7 @@ func main() {
 	// Send the flow entries
 	writeReples.Add(int(*count))
 	start := time.Now()
-	SendTableEntries(client, *count)
+	SendTableEntries(client, *count, *canonical)
 
 	// Wait for all writes to finish
 	<-doneChan
@@ -104,44 +176,28 @@ func main() {
 	fmt.Printf("Number of failed writes: %d\n", failedWrites)
 }
 
-func SendTableEntries(p4rt p4rt.P4RuntimeClient, count uint64) {
-	match := []*p4.FieldMatch{
-		{
-			FieldId:        1, // mpls_label
-			FieldMatchType: &p4.FieldMatch_Exact_{&p4.FieldMatch_Exact{}},
-		},
-		// more fields...
-		//{
-		//	FieldId:        0,
-		//	FieldMatchType: &p4.FieldMatch_Exact_{
-		//		Exact: &p4.FieldMatch_Exact{[]byte{4, 5, 6, 7}}},
-		//},
-	}
-
-	update := &p4.Update{
-		Type: p4.Update_INSERT,
-		Entity: &p4.Entity{Entity: &p4.Entity_TableEntry{
-			TableEntry: &p4.TableEntry{
-				TableId: 33574274, // FabricIngress.forwarding.mpls
-				Match:   match,
-				Action: &p4.TableAction{Type: &p4.TableAction_Action{Action: &p4.Action{
-					ActionId: 16827758, // pop_mpls_and_next
-					Params: []*p4.Action_Param{
-						{
-							ParamId: 1,              // next_id
-							Value:   Uint64(0)[0:4], // 32 bits
-						},
-					},
-				}}},
-			},
-		}},
+func SendTableEntries(client p4rt.P4RuntimeClient, count uint64, canonical bool) {
+	// mpls_label is set for each entry below
+	update, err := p4rt.NewTableEntry(client.P4Info(), "FabricIngress.forwarding.mpls").
+		Match("mpls_label", 0).
+		Action("pop_mpls_and_next").Param("next_id", 0).
+		Insert()
+	if err != nil {
+		panic(err)
 	}
 
 	for i := uint64(0); i < count; i++ {
 		//update.GetEntity().GetTableEntry().GetMatch()[0].FieldId = uint32(i % 2)
 		matchField := update.GetEntity().GetTableEntry().GetMatch()[0].GetExact()
-		matchField.Value = Uint64(i)[5:8] // mpls_label is 20 bits
-		res := p4rt.Write(update)
+		if canonical {
+			matchField.Value, err = p4rt.EncodeCanonical(i, 20) // mpls_label is 20 bits
+		} else {
+			matchField.Value, err = p4rt.EncodeFixedWidth(i, 20)
+		}
+		if err != nil {
+			panic(err)
+		}
+		res := client.Write(update)
 		go CountFailed(proto.Clone(update).(*p4.Update), res)
 	}
 }
@@ -154,9 +210,3 @@ func CountFailed(update *p4.Update, res <-chan *p4.Error) {
 	}
 	writeReples.Done()
 }
-
-func Uint64(v uint64) []byte {
-	bytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(bytes, v)
-	return bytes
-}
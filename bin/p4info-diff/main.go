@@ -0,0 +1,63 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// p4info-diff compares two P4Info files and exits with status 1 if there are breaking changes, e.g.
+//
+//	p4info-diff -old old/p4info.txt -new test/bmv2/p4info.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bocon13/p4rt-go/p4rt"
+	"os"
+)
+
+func main() {
+	oldPath := flag.String("old", "", "P4Info of the installed pipeline")
+	newPath := flag.String("new", "", "P4Info of the new pipeline")
+	breakingOnly := flag.Bool("breaking", false, "only print breaking changes")
+
+	flag.Parse()
+
+	if *oldPath == "" || *newPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	oldInfo, err := p4rt.LoadP4Info(*oldPath)
+	if err != nil {
+		panic(err)
+	}
+	newInfo, err := p4rt.LoadP4Info(*newPath)
+	if err != nil {
+		panic(err)
+	}
+
+	changes := p4rt.DiffP4Info(&oldInfo, &newInfo)
+	breaking := p4rt.BreakingChanges(changes)
+	if *breakingOnly {
+		changes = breaking
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	fmt.Printf("%d changes, %d breaking\n", len(changes), len(breaking))
+	if len(breaking) > 0 {
+		os.Exit(1)
+	}
+}
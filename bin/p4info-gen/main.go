@@ -0,0 +1,423 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// p4info-gen generates a Go package with typed constructors for the table entries and actions
+// of a P4 program, e.g.
+//
+//	p4info-gen -p4info test/bmv2/p4info.txt -package fabric -out fabric/p4info.go
+//
+// generates ForwardingMpls{MplsLabel: 5}.PopMplsAndNext(nextId), which returns the *p4.TableEntry.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/bocon13/p4rt-go/p4rt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	p4info := flag.String("p4info", "", "P4Info file")
+	pkg := flag.String("package", "p4info", "name of the generated package")
+	out := flag.String("out", "", "generated Go file")
+
+	flag.Parse()
+
+	if *p4info == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	info, err := p4rt.LoadP4Info(*p4info)
+	if err != nil {
+		panic(err)
+	}
+	src, err := generate(&info, *pkg, *p4info)
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(*out, src, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	p4info  *p4_config_v1.P4Info
+	actions map[uint32]string // action id -> Go name
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func generate(p4info *p4_config_v1.P4Info, pkg, source string) ([]byte, error) {
+	g := &generator{
+		p4info:  p4info,
+		actions: make(map[uint32]string),
+	}
+	g.printf("// Code generated by p4info-gen from %s. DO NOT EDIT.\n\n", source)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n")
+	g.printf("\t\"fmt\"\n")
+	g.printf("\t\"github.com/bocon13/p4rt-go/p4rt\"\n")
+	g.printf("\tp4_config_v1 \"github.com/p4lang/p4runtime/proto/p4/config/v1\"\n")
+	g.printf("\tp4 \"github.com/p4lang/p4runtime/proto/p4/v1\"\n")
+	g.printf(")\n\n")
+
+	tableNames := make(map[uint32]string)
+	var tablePreambles []*p4_config_v1.Preamble
+	for _, t := range p4info.GetTables() {
+		tablePreambles = append(tablePreambles, t.GetPreamble())
+	}
+	for id, name := range goNames(tablePreambles, tableName) {
+		tableNames[id] = name
+	}
+	var actionPreambles []*p4_config_v1.Preamble
+	for _, a := range p4info.GetActions() {
+		actionPreambles = append(actionPreambles, a.GetPreamble())
+	}
+	g.actions = goNames(actionPreambles, aliasName)
+
+	g.constants("Table", tablePreambles, tableNames)
+	g.constants("Action", actionPreambles, g.actions)
+	for _, c := range []struct {
+		prefix    string
+		preambles []*p4_config_v1.Preamble
+	}{
+		{"ActionProfile", preamblesOf(p4info.GetActionProfiles())},
+		{"Counter", preamblesOf(p4info.GetCounters())},
+		{"DirectCounter", preamblesOf(p4info.GetDirectCounters())},
+		{"Meter", preamblesOf(p4info.GetMeters())},
+		{"DirectMeter", preamblesOf(p4info.GetDirectMeters())},
+		{"Register", preamblesOf(p4info.GetRegisters())},
+		{"Digest", preamblesOf(p4info.GetDigests())},
+	} {
+		g.constants(c.prefix, c.preambles, goNames(c.preambles, aliasName))
+	}
+
+	for _, a := range p4info.GetActions() {
+		g.action(a)
+	}
+	for _, t := range p4info.GetTables() {
+		g.table(t, tableNames[t.GetPreamble().GetId()])
+	}
+	g.helpers()
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	return src, nil
+}
+
+func (g *generator) constants(prefix string, preambles []*p4_config_v1.Preamble, names map[uint32]string) {
+	if len(preambles) == 0 {
+		return
+	}
+	g.printf("const (\n")
+	for _, p := range preambles {
+		g.printf("\t%s%s uint32 = %d // %s\n", prefix, names[p.GetId()], p.GetId(), p.GetName())
+	}
+	g.printf(")\n\n")
+}
+
+func (g *generator) action(action *p4_config_v1.Action) {
+	name := g.actions[action.GetPreamble().GetId()]
+	paramsVar := lowerFirst(name) + "Params"
+	args, values := g.params(action)
+
+	g.printf("var %s = []*p4_config_v1.Action_Param{\n", paramsVar)
+	for _, p := range action.GetParams() {
+		g.printf("\t{Id: %d, Name: %q, Bitwidth: %d},\n", p.GetId(), p.GetName(), p.GetBitwidth())
+	}
+	g.printf("}\n\n")
+
+	g.printf("// %sAction builds %s\n", name, action.GetPreamble().GetName())
+	g.printf("func %sAction(%s) (*p4.Action, error) {\n", name, args)
+	g.printf("\treturn encodeAction(Action%s, %s%s)\n", name, paramsVar, values)
+	g.printf("}\n\n")
+}
+
+// params returns the parameter list and the argument values (with a leading ", ") of an action's params
+func (g *generator) params(action *p4_config_v1.Action) (string, string) {
+	var args, values []string
+	used := map[string]bool{}
+	for _, p := range action.GetParams() {
+		arg := uniqueName(goArg(p.GetName()), used)
+		args = append(args, fmt.Sprintf("%s %s", arg, valueType(p.GetBitwidth())))
+		values = append(values, ", "+arg)
+	}
+	return strings.Join(args, ", "), strings.Join(values, "")
+}
+
+func (g *generator) table(table *p4_config_v1.Table, name string) {
+	matchVar := lowerFirst(name) + "MatchFields"
+	used := map[string]bool{"Match": true, "Priority": true}
+	var fields []string
+	prioritized := false
+
+	g.printf("var %s = []*p4_config_v1.MatchField{\n", matchVar)
+	for _, mf := range table.GetMatchFields() {
+		g.printf("\t{Id: %d, Name: %q, Bitwidth: %d, Match: &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_%v}},\n",
+			mf.GetId(), mf.GetName(), mf.GetBitwidth(), mf.GetMatchType())
+		switch mf.GetMatchType() {
+		case p4_config_v1.MatchField_TERNARY, p4_config_v1.MatchField_RANGE, p4_config_v1.MatchField_OPTIONAL:
+			prioritized = true
+		}
+	}
+	g.printf("}\n\n")
+
+	g.printf("// %s is a key of %s\n", name, table.GetPreamble().GetName())
+	g.printf("type %s struct {\n", name)
+	for _, mf := range table.GetMatchFields() {
+		field := uniqueName(goName(mf.GetName()), used)
+		fields = append(fields, "t."+field)
+		g.printf("\t%s %s // %v, bit<%d>\n", field, matchType(mf), mf.GetMatchType(), mf.GetBitwidth())
+	}
+	if prioritized {
+		g.printf("\tPriority int32\n")
+	}
+	g.printf("}\n\n")
+
+	g.printf("func (t %s) Match() ([]*p4.FieldMatch, error) {\n", name)
+	g.printf("\treturn encodeMatch(%s%s)\n", matchVar, prefixJoin(", ", fields))
+	g.printf("}\n\n")
+
+	priority := "0"
+	if prioritized {
+		priority = "t.Priority"
+	}
+	for _, ref := range table.GetActionRefs() {
+		if ref.GetScope() == p4_config_v1.ActionRef_DEFAULT_ONLY {
+			continue
+		}
+		var action *p4_config_v1.Action
+		for _, a := range g.p4info.GetActions() {
+			if a.GetPreamble().GetId() == ref.GetId() {
+				action = a
+			}
+		}
+		if action == nil {
+			continue
+		}
+		actionName := g.actions[ref.GetId()]
+		method := actionName
+		if used[method] {
+			method += "Action"
+		}
+		args, values := g.params(action)
+		g.printf("// %s returns an entry of %s with action %s\n", method, table.GetPreamble().GetName(), action.GetPreamble().GetName())
+		g.printf("func (t %s) %s(%s) (*p4.TableEntry, error) {\n", name, method, args)
+		g.printf("\taction, err := %sAction(%s)\n", actionName, strings.TrimPrefix(values, ", "))
+		g.printf("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+		g.printf("\treturn tableEntry(Table%s, t.Match, %s, action)\n", name, priority)
+		g.printf("}\n\n")
+	}
+}
+
+func (g *generator) helpers() {
+	g.printf(`func encodeMatch(fields []*p4_config_v1.MatchField, values ...interface{}) ([]*p4.FieldMatch, error) {
+	var match []*p4.FieldMatch
+	for i, mf := range fields {
+		fm, err := p4rt.EncodeMatch(mf, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if fm != nil {
+			match = append(match, fm)
+		}
+	}
+	return match, nil
+}
+
+func encodeAction(actionId uint32, params []*p4_config_v1.Action_Param, values ...interface{}) (*p4.Action, error) {
+	action := &p4.Action{ActionId: actionId}
+	for i, p := range params {
+		value, err := p4rt.EncodeFixedWidth(values[i], p.GetBitwidth())
+		if err != nil {
+			return nil, fmt.Errorf("param %%s: %%v", p.GetName(), err)
+		}
+		action.Params = append(action.Params, &p4.Action_Param{ParamId: p.GetId(), Value: value})
+	}
+	return action, nil
+}
+
+func tableEntry(tableId uint32, key func() ([]*p4.FieldMatch, error), priority int32, action *p4.Action) (*p4.TableEntry, error) {
+	match, err := key()
+	if err != nil {
+		return nil, err
+	}
+	return &p4.TableEntry{
+		TableId:  tableId,
+		Match:    match,
+		Priority: priority,
+		Action:   &p4.TableAction{Type: &p4.TableAction_Action{Action: action}},
+	}, nil
+}
+`)
+}
+
+func preamblesOf(objects interface{}) []*p4_config_v1.Preamble {
+	var preambles []*p4_config_v1.Preamble
+	switch o := objects.(type) {
+	case []*p4_config_v1.ActionProfile:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.Counter:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.DirectCounter:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.Meter:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.DirectMeter:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.Register:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	case []*p4_config_v1.Digest:
+		for _, x := range o {
+			preambles = append(preambles, x.GetPreamble())
+		}
+	}
+	return preambles
+}
+
+// tableName drops the top-level control from the name, e.g. FabricIngress.forwarding.mpls -> ForwardingMpls
+func tableName(p *p4_config_v1.Preamble) string {
+	name := p.GetName()
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return goName(name)
+}
+
+func aliasName(p *p4_config_v1.Preamble) string {
+	if p.GetAlias() != "" {
+		return goName(p.GetAlias())
+	}
+	return goName(p.GetName())
+}
+
+// goNames names each object with short, falling back to the full name if short names collide
+func goNames(preambles []*p4_config_v1.Preamble, short func(*p4_config_v1.Preamble) string) map[uint32]string {
+	count := map[string]int{}
+	for _, p := range preambles {
+		count[short(p)]++
+	}
+	names := make(map[uint32]string)
+	used := map[string]bool{}
+	for _, p := range preambles {
+		name := short(p)
+		if count[name] > 1 {
+			name = goName(p.GetName())
+		}
+		names[p.GetId()] = uniqueName(name, used)
+	}
+	return names
+}
+
+// goName converts a P4 name, e.g. "hdr.ipv4.dst_addr", to an exported Go identifier (HdrIpv4DstAddr)
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+func goArg(name string) string {
+	arg := lowerFirst(goName(name))
+	if token.IsKeyword(arg) || arg == "t" || arg == "action" || arg == "err" {
+		arg += "_"
+	}
+	return arg
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+func valueType(bitwidth int32) string {
+	if bitwidth > 64 {
+		return "[]byte"
+	}
+	return "uint64"
+}
+
+func matchType(mf *p4_config_v1.MatchField) string {
+	switch mf.GetMatchType() {
+	case p4_config_v1.MatchField_EXACT:
+		return valueType(mf.GetBitwidth())
+	case p4_config_v1.MatchField_LPM:
+		return "p4rt.LpmValue"
+	case p4_config_v1.MatchField_TERNARY:
+		return "p4rt.TernaryValue"
+	case p4_config_v1.MatchField_RANGE:
+		return "p4rt.RangeValue"
+	}
+	return "interface{}" // nil is don't care
+}
+
+func prefixJoin(prefix string, s []string) string {
+	var b strings.Builder
+	for _, x := range s {
+		b.WriteString(prefix)
+		b.WriteString(x)
+	}
+	return b.String()
+}
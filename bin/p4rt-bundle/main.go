@@ -0,0 +1,215 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// p4rt-bundle creates and inspects pipeline bundles, e.g.
+//
+//	p4rt-bundle create -dir test/montara -out montara.tgz
+//	p4rt-bundle inspect montara.tgz
+//	p4rt-bundle device-config device_config.bin montara.tgz
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/bocon13/p4rt-go/p4rt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s create -dir <dir> -out <bundle> [flags]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s inspect <bundle>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s device-config <saved device config> [<bundle>]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "create":
+		create(os.Args[2:])
+	case "inspect":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		inspect(os.Args[2])
+	case "device-config":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			usage()
+		}
+		deviceConfig(os.Args[2], os.Args[3:])
+	default:
+		usage()
+	}
+}
+
+func create(args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory with the P4Info and compiler output")
+	out := flags.String("out", "", "bundle to write (.tar, .tar.gz, .tgz or .zip)")
+	target := flags.String("target", "", "bmv2, tofino, ... (default: detect from the artifacts)")
+	p4info := flags.String("p4info", "p4info.txt", "P4Info, relative to -dir")
+	artifacts := flags.String("artifacts", "", "comma-separated compiler output, relative to -dir "+
+		"(default: a p4c *.conf and the files it names, tofino.bin,context.json or bmv2.json)")
+	pipeconf := flags.String("pipeconf", "", "pipeconf name, for targets that use one")
+	flags.Parse(args)
+
+	if *dir == "" || *out == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	manifest := p4rt.BundleManifest{
+		Target:   *target,
+		P4Info:   *p4info,
+		Pipeconf: *pipeconf,
+	}
+	confs, _ := filepath.Glob(filepath.Join(*dir, "*.conf"))
+	var conf []byte
+	if *artifacts != "" {
+		manifest.Artifacts = strings.Split(*artifacts, ",")
+	} else if len(confs) == 1 {
+		// the .conf goes first, so that the tofino-bf builder reads the other files through it;
+		// its paths are rewritten relative to the bundle root, where it is stored
+		var files []string
+		var err error
+		conf, files, err = p4rt.BundleBfConf(confs[0], *dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		manifest.Artifacts = append([]string{filepath.Base(confs[0])}, files...)
+		if manifest.Target == "" {
+			manifest.Target = "tofino-bf"
+		}
+	} else if exists(*dir, "tofino.bin") && exists(*dir, "context.json") {
+		manifest.Artifacts = []string{"tofino.bin", "context.json"}
+		if manifest.Target == "" {
+			manifest.Target = "tofino"
+		}
+	} else if exists(*dir, "bmv2.json") {
+		manifest.Artifacts = []string{"bmv2.json"}
+		if manifest.Target == "" {
+			manifest.Target = "bmv2"
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "no known compiler output in %s; use -artifacts\n", *dir)
+		os.Exit(1)
+	}
+
+	bundle, err := p4rt.NewPipelineBundle(*dir, manifest)
+	if err != nil {
+		panic(err)
+	}
+	if conf != nil {
+		bundle.Files[manifest.Artifacts[0]] = conf
+	}
+	err = bundle.Write(*out)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Wrote %s\n", *out)
+}
+
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+func inspect(bundlePath string) {
+	bundle, err := p4rt.LoadPipelineBundle(bundlePath)
+	if err != nil {
+		panic(err)
+	}
+	m := bundle.Manifest
+	fmt.Printf("Target:    %s\n", m.Target)
+	fmt.Printf("Pipeconf:  %s\n", m.Pipeconf)
+	fmt.Printf("P4Info:    %s (%d bytes)\n", m.P4Info, len(bundle.Files[m.P4Info]))
+	for _, name := range m.Artifacts {
+		fmt.Printf("Artifact:  %s (%d bytes)\n", name, len(bundle.Files[name]))
+	}
+
+	p4info, err := bundle.P4Info()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Program:   %s (arch %s)\n", p4info.GetPkgInfo().GetName(), p4info.GetPkgInfo().GetArch())
+	fmt.Printf("Tables:    %d\n", len(p4info.GetTables()))
+	fmt.Printf("Actions:   %d\n", len(p4info.GetActions()))
+	for _, issue := range p4rt.LintP4Info(&p4info) {
+		fmt.Printf("Warning:   %v\n", issue)
+	}
+
+	config, err := bundle.PipelineConfig("")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Cookie:    0x%016x (%d byte device config)\n", config.GetCookie().GetCookie(), len(config.GetP4DeviceConfig()))
+}
+
+// deviceConfig prints the parts of a device config saved from a switch (see -saveDeviceConfig),
+// and compares it with the device config built from a bundle
+func deviceConfig(deviceConfigPath string, bundlePath []string) {
+	loaded, err := ioutil.ReadFile(deviceConfigPath)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Device config: %d bytes\n", len(loaded))
+	tofino, err := p4rt.ParseTofinoDeviceConfig(loaded)
+	if err == nil {
+		fmt.Printf("Pipeconf:  %s\n", tofino.PipeconfName)
+		fmt.Printf("Bin:       %d bytes\n", len(tofino.Bin))
+		fmt.Printf("Context:   %d bytes\n", len(tofino.Context))
+	} else if bf, bfErr := p4rt.ParseBfPipelineConfig(loaded); bfErr == nil {
+		fmt.Printf("Pipeconf:  %s (BfPipelineConfig)\n", bf.P4Name)
+		fmt.Printf("BfRt Info: %d bytes\n", len(bf.BfruntimeInfo))
+		for _, p := range bf.Profiles {
+			fmt.Printf("Profile:   %s, pipes %v: %d byte bin, %d byte context\n",
+				p.ProfileName, p.PipeScope, len(p.Binary), len(p.Context))
+		}
+	} else {
+		fmt.Printf("Not a Tofino device config: %v; %v\n", err, bfErr)
+	}
+	if len(bundlePath) == 0 {
+		return
+	}
+
+	bundle, err := p4rt.LoadPipelineBundle(bundlePath[0])
+	if err != nil {
+		panic(err)
+	}
+	built, err := bundle.DeviceConfig("")
+	if err != nil {
+		panic(err)
+	}
+	if bytes.Equal(loaded, built) {
+		fmt.Println("Matches the bundle")
+		return
+	}
+	if builtTofino, err := p4rt.ParseTofinoDeviceConfig(built); err == nil && tofino != nil {
+		for _, diff := range tofino.Diff(builtTofino) {
+			fmt.Printf("Differs:   %s\n", diff)
+		}
+	}
+	fmt.Println("Does not match the bundle")
+	os.Exit(1)
+}
@@ -0,0 +1,350 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BUNDLE_MANIFEST is the name of the manifest in a pipeline bundle
+const BUNDLE_MANIFEST = "manifest.json"
+
+// BundleManifest describes the files of a pipeline bundle; paths are relative to the bundle root
+type BundleManifest struct {
+	Target    string   `json:"target"`             // device config builder, e.g. "bmv2" or "tofino"
+	P4Info    string   `json:"p4info"`             // in any format read by LoadP4Info
+	Artifacts []string `json:"artifacts"`          // compiler output, in the order the builder expects; a first .conf is passed alone
+	Pipeconf  string   `json:"pipeconf,omitempty"` // pipeconf name, for targets that use one
+}
+
+// PipelineBundle is a P4Info and the compiler artifacts of a pipeline, packed in one archive
+// (.tar, .tar.gz, .tgz or .zip) or directory together with a manifest
+type PipelineBundle struct {
+	Manifest BundleManifest
+	Files    map[string][]byte
+}
+
+// NewPipelineBundle reads the files named by the manifest from dir
+func NewPipelineBundle(dir string, manifest BundleManifest) (*PipelineBundle, error) {
+	b := &PipelineBundle{Manifest: manifest, Files: map[string][]byte{}}
+	for _, name := range append([]string{manifest.P4Info}, manifest.Artifacts...) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		b.Files[name] = data
+	}
+	return b, b.Validate()
+}
+
+// IsPipelineBundle returns true if path is an archive or a directory with a manifest
+func IsPipelineBundle(bundlePath string) bool {
+	if bundleFormat(bundlePath) != "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(bundlePath, BUNDLE_MANIFEST))
+	return err == nil
+}
+
+// LoadPipelineBundle reads a bundle archive, or a directory with a manifest
+func LoadPipelineBundle(bundlePath string) (*PipelineBundle, error) {
+	fmt.Printf("Pipeline Bundle: %s\n", bundlePath)
+
+	var files map[string][]byte
+	var err error
+	switch bundleFormat(bundlePath) {
+	case "zip":
+		files, err = readZipBundle(bundlePath)
+	case "tar", "tgz":
+		files, err = readTarBundle(bundlePath)
+	default:
+		files = map[string][]byte{}
+		files[BUNDLE_MANIFEST], err = ioutil.ReadFile(filepath.Join(bundlePath, BUNDLE_MANIFEST))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle %s: %v", bundlePath, err)
+	}
+
+	var manifest BundleManifest
+	manifestData, ok := files[BUNDLE_MANIFEST]
+	if !ok {
+		return nil, fmt.Errorf("bundle %s has no %s", bundlePath, BUNDLE_MANIFEST)
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", BUNDLE_MANIFEST, err)
+	}
+	if bundleFormat(bundlePath) == "" {
+		return NewPipelineBundle(bundlePath, manifest)
+	}
+	delete(files, BUNDLE_MANIFEST)
+	b := &PipelineBundle{Manifest: manifest, Files: files}
+	return b, b.Validate()
+}
+
+// Validate checks that the manifest names a P4Info and artifacts that are in the bundle
+func (b *PipelineBundle) Validate() error {
+	if b.Manifest.P4Info == "" {
+		return fmt.Errorf("bundle manifest has no p4info")
+	}
+	if len(b.Manifest.Artifacts) == 0 {
+		return fmt.Errorf("bundle manifest has no artifacts")
+	}
+	for _, name := range append([]string{b.Manifest.P4Info}, b.Manifest.Artifacts...) {
+		if !validBundleName(name) {
+			return fmt.Errorf("bundle file name %q must be a clean relative path", name)
+		}
+		if name == BUNDLE_MANIFEST {
+			return fmt.Errorf("bundle file name %q is reserved", name)
+		}
+		if _, ok := b.Files[name]; !ok {
+			return fmt.Errorf("bundle has no file %s", name)
+		}
+	}
+	return nil
+}
+
+// validBundleName returns true if name is a clean relative path that stays inside the bundle
+func validBundleName(name string) bool {
+	if name == "" || path.Clean(name) != name || path.IsAbs(name) || name == "." {
+		return false
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// P4Info parses the bundle's P4Info
+func (b *PipelineBundle) P4Info() (p4info p4_config_v1.P4Info, err error) {
+	err = UnmarshalP4Info(b.Files[b.Manifest.P4Info], formatFromPath(b.Manifest.P4Info), &p4info)
+	return
+}
+
+// DeviceConfig builds the device config from the artifacts with the builder for target,
+// or for the manifest's target if target is empty. If the first artifact is a p4c .conf file
+// (tofino-bf), only its path is passed to the builder, which reads the other artifacts through it.
+func (b *PipelineBundle) DeviceConfig(target string) (P4DeviceConfig, error) {
+	if target == "" {
+		target = b.Manifest.Target
+	}
+
+	// Device config builders read files, so extract the artifacts
+	dir, err := ioutil.TempDir("", "p4rt-bundle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for _, name := range b.Manifest.Artifacts {
+		artifactPath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(artifactPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(artifactPath, b.Files[name], 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, artifactPath)
+	}
+	deviceConfigPath := strings.Join(paths, ",")
+	if strings.HasSuffix(b.Manifest.Artifacts[0], ".conf") {
+		deviceConfigPath = paths[0]
+	}
+
+	if b.Manifest.Pipeconf != "" {
+		switch target {
+		case "tofino":
+			return TofinoDeviceConfigBuilder(b.Manifest.Pipeconf, TofinoPacked)(deviceConfigPath)
+		case "tofino-bf":
+			return TofinoDeviceConfigBuilder(b.Manifest.Pipeconf, TofinoBfPipelineConfig)(deviceConfigPath)
+		}
+	}
+	return LoadTargetDeviceConfig(target, deviceConfigPath)
+}
+
+// PipelineConfig builds the pipeline to push with SetForwardingPipelineConfig (see DeviceConfig)
+func (b *PipelineBundle) PipelineConfig(target string) (*p4.ForwardingPipelineConfig, error) {
+	p4info, err := b.P4Info()
+	if err != nil {
+		return nil, err
+	}
+	deviceConfig, err := b.DeviceConfig(target)
+	if err != nil {
+		return nil, err
+	}
+	config := newPipelineConfig(&p4info, deviceConfig)
+	return &config, nil
+}
+
+// Write writes the bundle as an archive, in the format given by the extension of bundlePath
+func (b *PipelineBundle) Write(bundlePath string) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	names := append([]string{b.Manifest.P4Info}, b.Manifest.Artifacts...)
+	files := map[string][]byte{BUNDLE_MANIFEST: append(manifest, '\n')}
+	for _, name := range names {
+		files[name] = b.Files[name]
+	}
+	names = append([]string{BUNDLE_MANIFEST}, names...)
+
+	var buf bytes.Buffer
+	switch bundleFormat(bundlePath) {
+	case "zip":
+		err = writeZipBundle(&buf, names, files)
+	case "tar":
+		err = writeTarBundle(&buf, names, files)
+	case "tgz":
+		gz := gzip.NewWriter(&buf)
+		if err = writeTarBundle(gz, names, files); err == nil {
+			err = gz.Close()
+		}
+	default:
+		return fmt.Errorf("unknown bundle format %s (.tar, .tar.gz, .tgz or .zip)", bundlePath)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bundlePath, buf.Bytes(), 0644)
+}
+
+func bundleFormat(bundlePath string) string {
+	name := strings.ToLower(bundlePath)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	}
+	return ""
+}
+
+func readTarBundle(bundlePath string) (map[string][]byte, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if bundleFormat(bundlePath) == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(hdr.Name)] = data
+	}
+}
+
+func readZipBundle(bundlePath string) (map[string][]byte, error) {
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(f.Name)] = data
+	}
+	return files, nil
+}
+
+func writeTarBundle(w io.Writer, names []string, files map[string][]byte) error {
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeZipBundle(w io.Writer, names []string, files map[string][]byte) error {
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"math/big"
+)
+
+// P4Runtime 1.2+ requires bytestrings in canonical form: the shortest big-endian encoding of
+// the value, i.e. without leading zero bytes, with zero encoded as a single zero byte.
+
+// CanonicalBytes returns the canonical form of a bytestring (a sub-slice of b).
+// An empty bytestring is not a value, so it is returned as is for the target to reject.
+func CanonicalBytes(b []byte) []byte {
+	for i := range b {
+		if b[i] != 0 {
+			return b[i:]
+		}
+	}
+	if len(b) == 0 {
+		return b
+	}
+	return b[len(b)-1:]
+}
+
+// EncodeCanonical encodes an integer (see EncodeExactMatch for the accepted types) as a
+// canonical bytestring, checking that it fits in bitwidth bits
+func EncodeCanonical(value interface{}, bitwidth int32) ([]byte, error) {
+	i, err := fieldValue(value, bitwidth)
+	if err != nil {
+		return nil, err
+	}
+	if i.Sign() == 0 {
+		return []byte{0}, nil
+	}
+	return i.Bytes(), nil
+}
+
+// EncodeFixedWidth encodes an integer as a bytestring of ceil(bitwidth/8) bytes,
+// as expected by targets that predate canonical bytestrings
+func EncodeFixedWidth(value interface{}, bitwidth int32) ([]byte, error) {
+	i, err := fieldValue(value, bitwidth)
+	if err != nil {
+		return nil, err
+	}
+	return fixedWidthBytes(i, bitwidth), nil
+}
+
+// DecodeBigInt decodes a canonical or padded bytestring, checking that it fits in bitwidth bits
+func DecodeBigInt(b []byte, bitwidth int32) (*big.Int, error) {
+	if err := validateBytes(b, bitwidth); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func DecodeUint64(b []byte, bitwidth int32) (uint64, error) {
+	i, err := DecodeBigInt(b, bitwidth)
+	if err != nil {
+		return 0, err
+	}
+	if !i.IsUint64() {
+		return 0, fmt.Errorf("value 0x%x does not fit in a uint64", b)
+	}
+	return i.Uint64(), nil
+}
+
+// SetCanonicalBytestrings enables the conversion of match and action param values to canonical
+// form in all writes and reads, and of the values read back from the switch
+func (c *p4rtClient) SetCanonicalBytestrings(enabled bool) {
+	c.canonicalBytestrings = enabled
+}
+
+// CanonicalizeEntity converts the match and action param values of a table entry (including the
+// one of a direct counter or meter entry) or action profile member to canonical form in place
+func CanonicalizeEntity(entity *p4.Entity) {
+	switch e := entity.GetEntity().(type) {
+	case *p4.Entity_TableEntry:
+		canonicalizeTableEntry(e.TableEntry)
+	case *p4.Entity_DirectCounterEntry:
+		canonicalizeTableEntry(e.DirectCounterEntry.GetTableEntry())
+	case *p4.Entity_DirectMeterEntry:
+		canonicalizeTableEntry(e.DirectMeterEntry.GetTableEntry())
+	case *p4.Entity_ActionProfileMember:
+		canonicalizeAction(e.ActionProfileMember.GetAction())
+	}
+}
+
+func canonicalizeTableEntry(entry *p4.TableEntry) {
+	for _, fm := range entry.GetMatch() {
+		switch m := fm.GetFieldMatchType().(type) {
+		case *p4.FieldMatch_Exact_:
+			m.Exact.Value = CanonicalBytes(m.Exact.GetValue())
+		case *p4.FieldMatch_Lpm:
+			m.Lpm.Value = CanonicalBytes(m.Lpm.GetValue())
+		case *p4.FieldMatch_Ternary_:
+			m.Ternary.Value = CanonicalBytes(m.Ternary.GetValue())
+			m.Ternary.Mask = CanonicalBytes(m.Ternary.GetMask())
+		case *p4.FieldMatch_Range_:
+			m.Range.Low = CanonicalBytes(m.Range.GetLow())
+			m.Range.High = CanonicalBytes(m.Range.GetHigh())
+		case *p4.FieldMatch_Optional_:
+			m.Optional.Value = CanonicalBytes(m.Optional.GetValue())
+		}
+	}
+	switch a := entry.GetAction().GetType().(type) {
+	case *p4.TableAction_Action:
+		canonicalizeAction(a.Action)
+	case *p4.TableAction_ActionProfileActionSet:
+		for _, profileAction := range a.ActionProfileActionSet.GetActionProfileActions() {
+			canonicalizeAction(profileAction.GetAction())
+		}
+	}
+}
+
+func canonicalizeAction(action *p4.Action) {
+	for _, param := range action.GetParams() {
+		param.Value = CanonicalBytes(param.GetValue())
+	}
+}
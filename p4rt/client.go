@@ -20,18 +20,58 @@ package p4rt
 import (
 	"context"
 	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
 	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
 	"google.golang.org/genproto/googleapis/rpc/code"
+	"sync"
+	"time"
 )
 
 var p4rtClients = make(map[p4rtClientKey]P4RuntimeClient)
 
 type P4RuntimeClient interface {
 	SetMastership(electionId p4.Uint128) error
-	GetForwardingPipelineConfig() (*p4.ForwardingPipelineConfig, error)
-	SetForwardingPipelineConfig(p4InfoPath, deviceConfigPath string) error
+	GetForwardingPipelineConfig(opts ...PipelineOption) (*p4.ForwardingPipelineConfig, error)
+	SetForwardingPipelineConfig(p4InfoPath, deviceConfigPath string, opts ...PipelineOption) error
+	PushForwardingPipelineConfig(p4InfoPath, deviceConfigPath string, opts ...PipelineOption) (*PipelineResult, error)
+	CommitForwardingPipelineConfig() (*PipelineResult, error)
+	ReconcileForwardingPipelineConfig(p4InfoPath, deviceConfigPath string, opts ...PipelineOption) (*ReconcileResult, error)
+	SetTarget(target string)
+	SetP4Info(p4info *p4_config_v1.P4Info)
+	P4Info() *P4InfoIndex
 	Write(update *p4.Update) <-chan *p4.Error
+	Read(ctx context.Context, entities ...*p4.Entity) *ReadIterator
+	ReadTableEntries(ctx context.Context, tableId uint32) ([]*p4.TableEntry, error)
+	ReadActionProfileMembers(ctx context.Context, profileId uint32) ([]*p4.ActionProfileMember, error)
+	ReadActionProfileGroups(ctx context.Context, profileId uint32) ([]*p4.ActionProfileGroup, error)
+	ReadCounter(ctx context.Context, counterName string, index int64) (CounterValue, error)
+	ReadCounters(ctx context.Context, counterName string) ([]CounterValue, error)
+	ReadDirectCounter(ctx context.Context, entry *p4.TableEntry) (CounterValue, error)
+	ReadDirectCounters(ctx context.Context, tableName string) ([]CounterValue, error)
+	NewCounterPoller(counterName string, interval time.Duration) *CounterPoller
+	NewDirectCounterPoller(tableName string, interval time.Duration) *CounterPoller
+	WriteMeter(meterName string, index int64, config MeterConfig) <-chan *p4.Error
+	WriteDirectMeter(entry *p4.TableEntry, config MeterConfig) <-chan *p4.Error
+	ReadMeter(ctx context.Context, meterName string, index int64) (MeterConfig, error)
+	ReadMeters(ctx context.Context, meterName string) (map[int64]MeterConfig, error)
+	ReadDirectMeter(ctx context.Context, entry *p4.TableEntry) (MeterConfig, error)
+	WriteRegister(registerName string, index int64, value interface{}) <-chan *p4.Error
+	ReadRegister(ctx context.Context, registerName string, index int64) (interface{}, error)
+	ReadRegisters(ctx context.Context, registerName string) (map[int64]interface{}, error)
+	SendPacketOut(payload []byte, metadata map[string]interface{}) error
+	SetPacketInChan(packetInChan chan PacketIn)
+	EnableDigest(digestName string, config DigestConfig) <-chan *p4.Error
+	ModifyDigest(digestName string, config DigestConfig) <-chan *p4.Error
+	DisableDigest(digestName string) <-chan *p4.Error
+	SetDigestChan(digestChan chan DigestList, autoAck bool)
+	AckDigestList(digestId uint32, listId uint64) error
+	SetIdleTimeoutChan(idleTimeoutChan chan IdleTimeout)
+	SetIdleTimeoutPolicy(policy IdleTimeoutPolicy)
+	SetStreamErrorChan(errorChan chan *StreamError)
+	Dispatcher() *StreamDispatcher
 	SetWriteTraceChan(traceChan chan WriteTrace)
+	SetUpdateValidator(validator *UpdateValidator)
+	SetCanonicalBytestrings(enabled bool)
 }
 
 type p4rtClientKey struct {
@@ -40,12 +80,28 @@ type p4rtClientKey struct {
 }
 
 type p4rtClient struct {
-	client         p4.P4RuntimeClient
-	stream         p4.P4Runtime_StreamChannelClient
-	deviceId       uint64
-	electionId     p4.Uint128
-	writes         chan p4Write
-	writeTraceChan chan WriteTrace
+	client               p4.P4RuntimeClient
+	stream               p4.P4Runtime_StreamChannelClient
+	streamLock           sync.Mutex
+	deviceId             uint64
+	electionId           p4.Uint128
+	p4info               *P4InfoIndex
+	writes               chan p4Write
+	writeTraceChan       chan WriteTrace
+	validator            *UpdateValidator
+	packetInChan         chan PacketIn
+	digestChan           chan DigestList
+	digestAutoAck        bool
+	idleTimeoutChan      chan IdleTimeout
+	idleTimeoutPolicy    IdleTimeoutPolicy
+	streamErrorChan      chan *StreamError
+	streamHistory        []sentStreamMessage
+	streamHistoryNext    int
+	dispatcher           *StreamDispatcher
+	canonicalBytestrings bool
+	target               string
+	savedP4info          *p4_config_v1.P4Info // pushed with VERIFY_AND_SAVE, waiting for COMMIT
+	savedCookie          uint64
 }
 
 func (c *p4rtClient) Init() (err error) {
@@ -54,21 +110,37 @@ func (c *p4rtClient) Init() (err error) {
 	if err != nil {
 		return
 	}
+	c.dispatcher = NewStreamDispatcher()
+	c.dispatcher.Register(ArbitrationMessage, STREAM_HANDLER_QUEUE_SIZE, func(res *p4.StreamMessageResponse) {
+		if code.Code(res.GetArbitration().GetStatus().GetCode()) == code.Code_OK {
+			fmt.Println("client is master")
+		} else {
+			fmt.Println("client is not master")
+		}
+	})
+	c.dispatcher.Register(PacketMessage, STREAM_HANDLER_QUEUE_SIZE, func(res *p4.StreamMessageResponse) {
+		c.handlePacketIn(res.GetPacket())
+	})
+	c.dispatcher.Register(DigestMessage, STREAM_HANDLER_QUEUE_SIZE, func(res *p4.StreamMessageResponse) {
+		c.handleDigestList(res.GetDigest())
+	})
+	c.dispatcher.Register(IdleTimeoutNotificationMessage, STREAM_HANDLER_QUEUE_SIZE, func(res *p4.StreamMessageResponse) {
+		c.handleIdleTimeout(res.GetIdleTimeoutNotification())
+	})
+	c.dispatcher.Register(ErrorMessage, STREAM_HANDLER_QUEUE_SIZE, func(res *p4.StreamMessageResponse) {
+		c.handleStreamError(res.GetError())
+	})
 	go func() {
 		for {
 			res, err := c.stream.Recv()
 			if err != nil {
+				// the stream is broken; every further Recv would fail immediately
 				fmt.Printf("stream recv error: %v\n", err)
-			} else if arb := res.GetArbitration(); arb != nil {
-				if code.Code(arb.Status.Code) == code.Code_OK {
-					fmt.Println("client is master")
-				} else {
-					fmt.Println("client is not master")
-				}
-			} else {
+				return
+			}
+			if !c.dispatcher.Dispatch(res) {
 				fmt.Printf("stream recv: %v\n", res)
 			}
-
 		}
 	}()
 
@@ -81,6 +153,22 @@ func (c *p4rtClient) Init() (err error) {
 	return
 }
 
+// Dispatcher returns the dispatcher of stream messages, so that applications can add their own handlers
+func (c *p4rtClient) Dispatcher() *StreamDispatcher {
+	return c.dispatcher
+}
+
+// sendStream serializes sends on the stream channel, which is not safe for concurrent use
+func (c *p4rtClient) sendStream(req *p4.StreamMessageRequest) error {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+	err := c.stream.Send(req)
+	if err == nil {
+		c.recordSent(req)
+	}
+	return err
+}
+
 func GetP4RuntimeClient(host string, deviceId uint64) (P4RuntimeClient, error) {
 	key := p4rtClientKey{
 		host:     host,
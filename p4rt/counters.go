@@ -0,0 +1,266 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"time"
+)
+
+// CounterValue is a counter reading; Index is set for indirect counters and Entry for direct counters
+type CounterValue struct {
+	Index   int64
+	Entry   *p4.TableEntry
+	Bytes   int64
+	Packets int64
+}
+
+// CounterRate is the change of a counter between two successive reads
+type CounterRate struct {
+	CounterValue
+	Interval      time.Duration
+	DeltaBytes    int64
+	DeltaPackets  int64
+	BytesPerSec   float64
+	PacketsPerSec float64
+}
+
+// CounterSample is the result of one poll of a CounterPoller
+type CounterSample struct {
+	Time  time.Time
+	Rates []CounterRate
+	Err   error
+}
+
+func (c *p4rtClient) findCounter(name string) (*p4_config_v1.Counter, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.Counter(name)
+}
+
+// findDirectCounterTable returns the id of a table, checking that it has a direct counter
+func (c *p4rtClient) findDirectCounterTable(name string) (uint32, error) {
+	index, err := c.index()
+	if err != nil {
+		return 0, err
+	}
+	table, err := index.Table(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := index.DirectCounterForTable(table.GetPreamble().GetId()); err != nil {
+		return 0, err
+	}
+	return table.GetPreamble().GetId(), nil
+}
+
+func (c *p4rtClient) ReadCounter(ctx context.Context, counterName string, index int64) (CounterValue, error) {
+	values, err := c.readCounters(ctx, counterName, &p4.Index{Index: index})
+	if err != nil {
+		return CounterValue{}, err
+	}
+	if len(values) != 1 {
+		return CounterValue{}, fmt.Errorf("expected 1 entry for counter %s[%d], got %d", counterName, index, len(values))
+	}
+	return values[0], nil
+}
+
+// ReadCounters reads all indices of an indirect counter
+func (c *p4rtClient) ReadCounters(ctx context.Context, counterName string) ([]CounterValue, error) {
+	return c.readCounters(ctx, counterName, nil)
+}
+
+func (c *p4rtClient) readCounters(ctx context.Context, counterName string, index *p4.Index) ([]CounterValue, error) {
+	counter, err := c.findCounter(counterName)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_CounterEntry{
+		CounterEntry: &p4.CounterEntry{CounterId: counter.GetPreamble().GetId(), Index: index},
+	}}).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]CounterValue, 0, len(entities))
+	for _, entity := range entities {
+		if entry := entity.GetCounterEntry(); entry != nil {
+			values = append(values, CounterValue{
+				Index:   entry.GetIndex().GetIndex(),
+				Bytes:   entry.GetData().GetByteCount(),
+				Packets: entry.GetData().GetPacketCount(),
+			})
+		}
+	}
+	return values, nil
+}
+
+// ReadDirectCounter reads the direct counter of a single table entry (only the match and priority are used)
+func (c *p4rtClient) ReadDirectCounter(ctx context.Context, entry *p4.TableEntry) (CounterValue, error) {
+	values, err := c.readDirectCounters(ctx, &p4.TableEntry{
+		TableId:  entry.GetTableId(),
+		Match:    entry.GetMatch(),
+		Priority: entry.GetPriority(),
+	})
+	if err != nil {
+		return CounterValue{}, err
+	}
+	if len(values) != 1 {
+		return CounterValue{}, fmt.Errorf("expected 1 direct counter entry for table %d, got %d",
+			entry.GetTableId(), len(values))
+	}
+	return values[0], nil
+}
+
+// ReadDirectCounters reads the direct counters of all entries in a table
+func (c *p4rtClient) ReadDirectCounters(ctx context.Context, tableName string) ([]CounterValue, error) {
+	tableId, err := c.findDirectCounterTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return c.readDirectCounters(ctx, &p4.TableEntry{TableId: tableId})
+}
+
+func (c *p4rtClient) readDirectCounters(ctx context.Context, entry *p4.TableEntry) ([]CounterValue, error) {
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_DirectCounterEntry{
+		DirectCounterEntry: &p4.DirectCounterEntry{TableEntry: entry},
+	}}).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]CounterValue, 0, len(entities))
+	for _, entity := range entities {
+		if entry := entity.GetDirectCounterEntry(); entry != nil {
+			values = append(values, CounterValue{
+				Entry:   entry.GetTableEntry(),
+				Bytes:   entry.GetData().GetByteCount(),
+				Packets: entry.GetData().GetPacketCount(),
+			})
+		}
+	}
+	return values, nil
+}
+
+// CounterPoller periodically reads a set of counters and computes rates between successive reads
+type CounterPoller struct {
+	read     func(ctx context.Context) ([]CounterValue, error)
+	interval time.Duration
+	last     map[string]CounterValue
+	lastTime time.Time
+}
+
+func (c *p4rtClient) NewCounterPoller(counterName string, interval time.Duration) *CounterPoller {
+	return &CounterPoller{
+		read: func(ctx context.Context) ([]CounterValue, error) {
+			return c.ReadCounters(ctx, counterName)
+		},
+		interval: interval,
+	}
+}
+
+func (c *p4rtClient) NewDirectCounterPoller(tableName string, interval time.Duration) *CounterPoller {
+	return &CounterPoller{
+		read: func(ctx context.Context) ([]CounterValue, error) {
+			return c.ReadDirectCounters(ctx, tableName)
+		},
+		interval: interval,
+	}
+}
+
+// Poll reads the counters once and returns the rates of the counters that were also present in the
+// previous read; the first call only records a baseline and returns no rates
+func (p *CounterPoller) Poll(ctx context.Context) ([]CounterRate, error) {
+	values, err := p.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	interval := now.Sub(p.lastTime)
+
+	var rates []CounterRate
+	current := make(map[string]CounterValue, len(values))
+	for _, value := range values {
+		key := counterKey(value)
+		current[key] = value
+		prev, ok := p.last[key]
+		if !ok {
+			continue
+		}
+		rate := CounterRate{
+			CounterValue: value,
+			Interval:     interval,
+			DeltaBytes:   value.Bytes - prev.Bytes,
+			DeltaPackets: value.Packets - prev.Packets,
+		}
+		if rate.DeltaBytes < 0 || rate.DeltaPackets < 0 {
+			// counter was reset between reads
+			rate.DeltaBytes = value.Bytes
+			rate.DeltaPackets = value.Packets
+		}
+		if interval > 0 {
+			rate.BytesPerSec = float64(rate.DeltaBytes) / interval.Seconds()
+			rate.PacketsPerSec = float64(rate.DeltaPackets) / interval.Seconds()
+		}
+		rates = append(rates, rate)
+	}
+	p.last = current
+	p.lastTime = now
+	return rates, nil
+}
+
+// Run polls the counters every interval until ctx is done, then closes the returned channel
+func (p *CounterPoller) Run(ctx context.Context) <-chan CounterSample {
+	samples := make(chan CounterSample, 1)
+	go func() {
+		defer close(samples)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			baseline := p.last == nil
+			rates, err := p.Poll(ctx)
+			if !baseline || err != nil {
+				select {
+				case samples <- CounterSample{Time: p.lastTime, Rates: rates, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples
+}
+
+func counterKey(value CounterValue) string {
+	if value.Entry == nil {
+		return fmt.Sprint(value.Index)
+	}
+	return proto.CompactTextString(&p4.TableEntry{
+		Match:    value.Entry.GetMatch(),
+		Priority: value.Entry.GetPriority(),
+	})
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeviceConfigBuilder builds the target-specific p4_device_config from the compiler
+// output at deviceConfigPath (a comma-separated list of paths for targets that need several files)
+type DeviceConfigBuilder func(deviceConfigPath string) (P4DeviceConfig, error)
+
+var deviceConfigBuilders = struct {
+	sync.RWMutex
+	builders map[string]DeviceConfigBuilder
+}{
+	builders: map[string]DeviceConfigBuilder{
+		"bmv2":      loadBmv2DeviceConfig,
+		"tofino":    TofinoDeviceConfigBuilder("", TofinoPacked),
+		"tofino-bf": TofinoDeviceConfigBuilder("", TofinoBfPipelineConfig),
+		"raw":       loadRawDeviceConfig,
+	},
+}
+
+// RegisterDeviceConfigBuilder adds or replaces the builder for a target
+func RegisterDeviceConfigBuilder(target string, builder DeviceConfigBuilder) {
+	deviceConfigBuilders.Lock()
+	defer deviceConfigBuilders.Unlock()
+	deviceConfigBuilders.builders[target] = builder
+}
+
+// DeviceConfigTargets returns the targets with a registered builder
+func DeviceConfigTargets() []string {
+	deviceConfigBuilders.RLock()
+	defer deviceConfigBuilders.RUnlock()
+	var targets []string
+	for target := range deviceConfigBuilders.builders {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// LoadDeviceConfig builds the device config for the target detected from deviceConfigPath
+func LoadDeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
+	return LoadTargetDeviceConfig("", deviceConfigPath)
+}
+
+// LoadTargetDeviceConfig builds the device config with the builder registered for target.
+// If target is empty, it is detected from the file names: "*.bin,*.json" is tofino,
+// "*.conf" is tofino-bf and "*.json" is bmv2.
+func LoadTargetDeviceConfig(target, deviceConfigPath string) (P4DeviceConfig, error) {
+	if target == "" {
+		target = detectTarget(deviceConfigPath)
+		if target == "" {
+			return nil, fmt.Errorf("cannot detect the target of device config %s; "+
+				"specify one of %s", deviceConfigPath, strings.Join(DeviceConfigTargets(), ", "))
+		}
+	}
+	deviceConfigBuilders.RLock()
+	builder, ok := deviceConfigBuilders.builders[target]
+	deviceConfigBuilders.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no device config builder for target %q (registered: %s)",
+			target, strings.Join(DeviceConfigTargets(), ", "))
+	}
+	return builder(deviceConfigPath)
+}
+
+func detectTarget(deviceConfigPath string) string {
+	paths := strings.Split(deviceConfigPath, ",")
+	for i := range paths {
+		paths[i] = strings.TrimSpace(paths[i])
+	}
+	switch {
+	case len(paths) == 2 && strings.HasSuffix(paths[0], ".bin") && strings.HasSuffix(paths[1], ".json"):
+		return "tofino"
+	case len(paths) == 1 && strings.HasSuffix(paths[0], ".conf"):
+		return "tofino-bf"
+	case len(paths) == 1 && strings.HasSuffix(paths[0], ".json"):
+		return "bmv2"
+	}
+	return ""
+}
+
+// SaveDeviceConfig writes a device config as returned by the switch to deviceConfigPath.
+// A single file can be loaded back with the raw target, or detected as bmv2 if it is named *.json;
+// a packed Tofino device config is split into "tofino.bin,context.json".
+// A BfPipelineConfig is not split, since its bf-rt.json, profile names and pipe scopes would be lost; save it
+// to a single file and load it back with the raw target. The pipeconf name of a split Tofino device config is printed; it is not in the files, so it must be
+// given back (e.g. with TOFINO_PIPECONF_NAME) to rebuild the same device config.
+func SaveDeviceConfig(deviceConfigPath string, deviceConfig P4DeviceConfig) error {
+	if detectTarget(deviceConfigPath) == "tofino" {
+		tofino, err := ParseTofinoDeviceConfig(deviceConfig)
+		if err != nil {
+			if _, bfErr := ParseBfPipelineConfig(deviceConfig); bfErr == nil {
+				return fmt.Errorf("cannot split a BfPipelineConfig into %s without losing its bf-rt.json, "+
+					"profile names and pipe scopes; save it to a single file and load it with the raw target", deviceConfigPath)
+			}
+			return err
+		}
+		fmt.Printf("Tofino Pipeconf: %s\n", tofino.PipeconfName)
+		paths := strings.Split(deviceConfigPath, ",")
+		return tofino.Write(strings.TrimSpace(paths[0]), strings.TrimSpace(paths[1]))
+	}
+	if strings.Contains(deviceConfigPath, ",") {
+		return fmt.Errorf("cannot split the device config into %s; save it to a single file", deviceConfigPath)
+	}
+	return ioutil.WriteFile(deviceConfigPath, deviceConfig, 0644)
+}
+
+// loadRawDeviceConfig uses the contents of the file as is, e.g. for a device config saved from the switch
+func loadRawDeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
+	fmt.Printf("Device Config: %s\n", deviceConfigPath)
+	return ioutil.ReadFile(deviceConfigPath)
+}
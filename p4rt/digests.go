@@ -0,0 +1,152 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+// DigestConfig controls how the switch batches digest messages
+type DigestConfig struct {
+	MaxTimeout  time.Duration // max time to wait before sending a list; 0 sends each digest immediately
+	MaxListSize int32         // max number of digests per list; 0 means no limit
+	AckTimeout  time.Duration // time before an unacknowledged list may be resent
+}
+
+// DigestList is a received list of digests, each decoded according to the digest's type_spec (see DecodeP4Data)
+type DigestList struct {
+	Name      string
+	DigestId  uint32
+	ListId    uint64
+	Timestamp time.Time
+	Data      []interface{}
+	Err       error // set if the data could not be decoded; Data then holds the raw *p4.P4Data
+}
+
+func (c *p4rtClient) findDigest(name string) (*p4_config_v1.Digest, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.Digest(name)
+}
+
+func (c *p4rtClient) findDigestById(id uint32) (*p4_config_v1.Digest, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.DigestById(id)
+}
+
+// EnableDigest configures the switch to generate the named digest;
+// use ModifyDigest to change the config of a digest that is already enabled
+func (c *p4rtClient) EnableDigest(digestName string, config DigestConfig) <-chan *p4.Error {
+	return c.writeDigestEntry(p4.Update_INSERT, digestName, &p4.DigestEntry_Config{
+		MaxTimeoutNs: config.MaxTimeout.Nanoseconds(),
+		MaxListSize:  config.MaxListSize,
+		AckTimeoutNs: config.AckTimeout.Nanoseconds(),
+	})
+}
+
+func (c *p4rtClient) ModifyDigest(digestName string, config DigestConfig) <-chan *p4.Error {
+	return c.writeDigestEntry(p4.Update_MODIFY, digestName, &p4.DigestEntry_Config{
+		MaxTimeoutNs: config.MaxTimeout.Nanoseconds(),
+		MaxListSize:  config.MaxListSize,
+		AckTimeoutNs: config.AckTimeout.Nanoseconds(),
+	})
+}
+
+func (c *p4rtClient) DisableDigest(digestName string) <-chan *p4.Error {
+	return c.writeDigestEntry(p4.Update_DELETE, digestName, nil)
+}
+
+func (c *p4rtClient) writeDigestEntry(updateType p4.Update_Type, digestName string, config *p4.DigestEntry_Config) <-chan *p4.Error {
+	digest, err := c.findDigest(digestName)
+	if err != nil {
+		return failedWrite(localError(codes.NotFound, "%v", err))
+	}
+	return c.Write(&p4.Update{
+		Type: updateType,
+		Entity: &p4.Entity{Entity: &p4.Entity_DigestEntry{DigestEntry: &p4.DigestEntry{
+			DigestId: digest.GetPreamble().GetId(),
+			Config:   config,
+		}}},
+	})
+}
+
+// SetDigestChan sets the channel on which received digest lists are delivered; nil discards them.
+// If autoAck is true, each list is acknowledged as soon as it is received; otherwise the
+// application must call AckDigestList once it has processed the list.
+func (c *p4rtClient) SetDigestChan(digestChan chan DigestList, autoAck bool) {
+	c.digestChan = digestChan
+	c.digestAutoAck = autoAck
+}
+
+func (c *p4rtClient) AckDigestList(digestId uint32, listId uint64) error {
+	return c.sendStream(&p4.StreamMessageRequest{
+		Update: &p4.StreamMessageRequest_DigestAck{DigestAck: &p4.DigestListAck{
+			DigestId: digestId,
+			ListId:   listId,
+		}},
+	})
+}
+
+func (c *p4rtClient) handleDigestList(digestList *p4.DigestList) {
+	if c.digestAutoAck {
+		if err := c.AckDigestList(digestList.GetDigestId(), digestList.GetListId()); err != nil {
+			fmt.Printf("failed to ack digest list %d: %v\n", digestList.GetListId(), err)
+		}
+	}
+	if c.digestChan == nil {
+		return
+	}
+
+	list := DigestList{
+		DigestId:  digestList.GetDigestId(),
+		ListId:    digestList.GetListId(),
+		Timestamp: time.Unix(0, digestList.GetTimestamp()),
+		Data:      make([]interface{}, len(digestList.GetData())),
+	}
+	if digest, err := c.findDigestById(digestList.GetDigestId()); err == nil {
+		list.Name = digest.GetPreamble().GetName()
+		for i, data := range digestList.GetData() {
+			list.Data[i], list.Err = DecodeP4Data(data, digest.GetTypeSpec(), c.p4info.P4Info().GetTypeInfo())
+			if list.Err != nil {
+				break
+			}
+		}
+	} else {
+		list.Err = err
+	}
+	if list.Err != nil {
+		for i, data := range digestList.GetData() {
+			list.Data[i] = data
+		}
+	}
+
+	select {
+	case c.digestChan <- list: // put digest list into the channel unless it is full
+	default:
+		fmt.Println("Digest channel full. Discarding digest list")
+	}
+}
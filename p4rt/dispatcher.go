@@ -0,0 +1,162 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"sync"
+	"sync/atomic"
+)
+
+// Queue size of the handlers the client registers for its own stream processing
+var STREAM_HANDLER_QUEUE_SIZE = 1000
+
+type StreamMessageType int
+
+const (
+	ArbitrationMessage StreamMessageType = iota
+	PacketMessage
+	DigestMessage
+	IdleTimeoutNotificationMessage
+	ErrorMessage
+	OtherMessage // architecture-specific extensions (google.protobuf.Any)
+)
+
+func (t StreamMessageType) String() string {
+	switch t {
+	case ArbitrationMessage:
+		return "arbitration"
+	case PacketMessage:
+		return "packet"
+	case DigestMessage:
+		return "digest"
+	case IdleTimeoutNotificationMessage:
+		return "idle_timeout_notification"
+	case ErrorMessage:
+		return "error"
+	case OtherMessage:
+		return "other"
+	}
+	return fmt.Sprintf("StreamMessageType(%d)", int(t))
+}
+
+func streamMessageType(res *p4.StreamMessageResponse) StreamMessageType {
+	switch res.GetUpdate().(type) {
+	case *p4.StreamMessageResponse_Arbitration:
+		return ArbitrationMessage
+	case *p4.StreamMessageResponse_Packet:
+		return PacketMessage
+	case *p4.StreamMessageResponse_Digest:
+		return DigestMessage
+	case *p4.StreamMessageResponse_IdleTimeoutNotification:
+		return IdleTimeoutNotificationMessage
+	case *p4.StreamMessageResponse_Error:
+		return ErrorMessage
+	}
+	return OtherMessage
+}
+
+type StreamHandler func(res *p4.StreamMessageResponse)
+
+// StreamDispatcher delivers stream messages to the handlers registered for their type.
+// Each handler runs in its own goroutine and has a bounded queue, so a slow handler only
+// drops its own messages (when its queue is full) and never blocks the stream or other handlers.
+type StreamDispatcher struct {
+	lock     sync.RWMutex
+	handlers map[StreamMessageType][]*streamHandler
+}
+
+type streamHandler struct {
+	handle  StreamHandler
+	typeUrl string // for OtherMessage handlers; "" matches all extensions
+	queue   chan *p4.StreamMessageResponse
+	dropped uint64
+}
+
+func NewStreamDispatcher() *StreamDispatcher {
+	return &StreamDispatcher{
+		handlers: make(map[StreamMessageType][]*streamHandler),
+	}
+}
+
+// Register adds a handler for a message type and returns a function that removes it
+func (d *StreamDispatcher) Register(msgType StreamMessageType, queueSize int, handler StreamHandler) (unregister func()) {
+	return d.register(msgType, "", queueSize, handler)
+}
+
+// RegisterOther adds a handler for "other" messages whose Any type URL is typeUrl ("" for all)
+func (d *StreamDispatcher) RegisterOther(typeUrl string, queueSize int, handler StreamHandler) (unregister func()) {
+	return d.register(OtherMessage, typeUrl, queueSize, handler)
+}
+
+func (d *StreamDispatcher) register(msgType StreamMessageType, typeUrl string, queueSize int,
+	handler StreamHandler) func() {
+	h := &streamHandler{
+		handle:  handler,
+		typeUrl: typeUrl,
+		queue:   make(chan *p4.StreamMessageResponse, queueSize),
+	}
+	go func() {
+		for res := range h.queue {
+			h.handle(res)
+		}
+	}()
+
+	d.lock.Lock()
+	d.handlers[msgType] = append(d.handlers[msgType], h)
+	d.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			handlers := d.handlers[msgType]
+			for i := range handlers {
+				if handlers[i] == h {
+					d.handlers[msgType] = append(handlers[:i:i], handlers[i+1:]...)
+					break
+				}
+			}
+			close(h.queue)
+		})
+	}
+}
+
+// Dispatch queues the message on each matching handler; it returns false if there was no handler
+func (d *StreamDispatcher) Dispatch(res *p4.StreamMessageResponse) bool {
+	msgType := streamMessageType(res)
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	handled := false
+	for _, h := range d.handlers[msgType] {
+		if h.typeUrl != "" && h.typeUrl != res.GetOther().GetTypeUrl() {
+			continue
+		}
+		handled = true
+		select {
+		case h.queue <- res:
+		default:
+			if dropped := atomic.AddUint64(&h.dropped, 1); dropped == 1 || dropped%1000 == 0 {
+				fmt.Printf("Stream handler queue for %v full. Discarded %d messages\n", msgType, dropped)
+			}
+		}
+	}
+	return handled
+}
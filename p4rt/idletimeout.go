@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+type IdleTimeoutPolicy int
+
+const (
+	// IdleTimeoutNotify only delivers notifications (on the idle timeout channel, if set)
+	IdleTimeoutNotify IdleTimeoutPolicy = iota
+	// IdleTimeoutDelete also deletes the expired entries through the write pipeline
+	IdleTimeoutDelete
+)
+
+// IdleTimeout is a received idle timeout notification. Each entry has its key fields
+// (table_id, match and priority) and its controller_metadata, metadata and idle_timeout_ns.
+type IdleTimeout struct {
+	Timestamp time.Time
+	Entries   []*p4.TableEntry
+}
+
+// SetIdleTimeoutChan sets the channel on which idle timeout notifications are delivered; nil discards them
+func (c *p4rtClient) SetIdleTimeoutChan(idleTimeoutChan chan IdleTimeout) {
+	c.idleTimeoutChan = idleTimeoutChan
+}
+
+func (c *p4rtClient) SetIdleTimeoutPolicy(policy IdleTimeoutPolicy) {
+	c.idleTimeoutPolicy = policy
+}
+
+func (c *p4rtClient) handleIdleTimeout(notification *p4.IdleTimeoutNotification) {
+	if c.idleTimeoutPolicy == IdleTimeoutDelete {
+		for _, entry := range notification.GetTableEntry() {
+			res := c.Write(&p4.Update{
+				Type: p4.Update_DELETE,
+				Entity: &p4.Entity{Entity: &p4.Entity_TableEntry{TableEntry: &p4.TableEntry{
+					TableId:  entry.GetTableId(),
+					Match:    entry.GetMatch(),
+					Priority: entry.GetPriority(),
+				}}},
+			})
+			go func(entry *p4.TableEntry) {
+				// NOT_FOUND means the entry was already deleted, e.g. by the application
+				if err := <-res; err.GetCanonicalCode() != int32(codes.OK) &&
+					err.GetCanonicalCode() != int32(codes.NotFound) {
+					fmt.Printf("failed to delete idle entry %v: %v\n", entry, err.GetMessage())
+				}
+			}(entry)
+		}
+	}
+	if c.idleTimeoutChan == nil {
+		return
+	}
+
+	idleTimeout := IdleTimeout{
+		Timestamp: time.Unix(0, notification.GetTimestamp()),
+		Entries:   notification.GetTableEntry(),
+	}
+	select {
+	case c.idleTimeoutChan <- idleTimeout: // put notification into the channel unless it is full
+	default:
+		fmt.Println("Idle timeout channel full. Discarding notification")
+	}
+}
@@ -31,6 +31,6 @@ func (c *p4rtClient) SetMastership(electionId p4.Uint128) (err error) {
 			},
 		},
 	}
-	err = c.stream.Send(mastershipReq)
+	err = c.sendStream(mastershipReq)
 	return
 }
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"math/big"
+	"net"
+)
+
+// The match encoders accept integers, *big.Int, []byte, net.IP, net.IPMask and net.HardwareAddr
+// values and encode them to the bitwidth of the match field. Encoders for the match kinds that
+// can be "don't care" return a nil FieldMatch when the match covers every value; P4Runtime
+// requires such fields to be omitted from the entry.
+
+// Match values for the match kinds that take more than one value (see EncodeMatch)
+type LpmValue struct {
+	Value     interface{}
+	PrefixLen int32
+}
+
+type TernaryValue struct {
+	Value, Mask interface{}
+}
+
+type RangeValue struct {
+	Low, High interface{}
+}
+
+// EncodeMatch encodes a value of the type expected by the kind of the match field: an integer,
+// []byte, net.IP or net.HardwareAddr for exact and optional matches, LpmValue or *net.IPNet for
+// LPM, TernaryValue for ternary and RangeValue for range. A nil optional value, a nil ternary
+// mask and a nil range bound are "don't care".
+func EncodeMatch(mf *p4_config_v1.MatchField, value interface{}) (*p4.FieldMatch, error) {
+	switch mf.GetMatchType() {
+	case p4_config_v1.MatchField_EXACT:
+		return EncodeExactMatch(mf, value)
+	case p4_config_v1.MatchField_OPTIONAL:
+		if value == nil {
+			return nil, nil
+		}
+		return EncodeOptionalMatch(mf, value)
+	case p4_config_v1.MatchField_LPM:
+		switch v := value.(type) {
+		case LpmValue:
+			return EncodeLpmMatch(mf, v.Value, v.PrefixLen)
+		case *net.IPNet:
+			return EncodeLpmPrefix(mf, v)
+		}
+	case p4_config_v1.MatchField_TERNARY:
+		if v, ok := value.(TernaryValue); ok {
+			if v.Mask == nil {
+				return nil, nil
+			}
+			return EncodeTernaryMatch(mf, v.Value, v.Mask)
+		}
+	case p4_config_v1.MatchField_RANGE:
+		if v, ok := value.(RangeValue); ok {
+			if v.Low == nil {
+				v.Low = 0
+			}
+			if v.High == nil {
+				v.High = prefixMask(mf.GetBitwidth(), mf.GetBitwidth())
+			}
+			return EncodeRangeMatch(mf, v.Low, v.High)
+		}
+	}
+	return nil, fmt.Errorf("match field %s is %v; cannot match it on a %T", mf.GetName(), mf.GetMatchType(), value)
+}
+
+func EncodeExactMatch(mf *p4_config_v1.MatchField, value interface{}) (*p4.FieldMatch, error) {
+	if err := checkMatchType(mf, p4_config_v1.MatchField_EXACT); err != nil {
+		return nil, err
+	}
+	v, err := fieldValue(value, mf.GetBitwidth())
+	if err != nil {
+		return nil, fmt.Errorf("match field %s: %v", mf.GetName(), err)
+	}
+	return &p4.FieldMatch{
+		FieldId: mf.GetId(),
+		FieldMatchType: &p4.FieldMatch_Exact_{Exact: &p4.FieldMatch_Exact{
+			Value: fixedWidthBytes(v, mf.GetBitwidth()),
+		}},
+	}, nil
+}
+
+func EncodeOptionalMatch(mf *p4_config_v1.MatchField, value interface{}) (*p4.FieldMatch, error) {
+	if err := checkMatchType(mf, p4_config_v1.MatchField_OPTIONAL); err != nil {
+		return nil, err
+	}
+	v, err := fieldValue(value, mf.GetBitwidth())
+	if err != nil {
+		return nil, fmt.Errorf("match field %s: %v", mf.GetName(), err)
+	}
+	return &p4.FieldMatch{
+		FieldId: mf.GetId(),
+		FieldMatchType: &p4.FieldMatch_Optional_{Optional: &p4.FieldMatch_Optional{
+			Value: fixedWidthBytes(v, mf.GetBitwidth()),
+		}},
+	}, nil
+}
+
+// EncodeLpmMatch masks value to its first prefixLen bits; a prefix length of 0 is "don't care"
+func EncodeLpmMatch(mf *p4_config_v1.MatchField, value interface{}, prefixLen int32) (*p4.FieldMatch, error) {
+	if err := checkMatchType(mf, p4_config_v1.MatchField_LPM); err != nil {
+		return nil, err
+	}
+	bitwidth := mf.GetBitwidth()
+	if prefixLen < 0 || prefixLen > bitwidth {
+		return nil, fmt.Errorf("match field %s: prefix length %d is out of range (0-%d)", mf.GetName(), prefixLen, bitwidth)
+	}
+	if prefixLen == 0 {
+		return nil, nil
+	}
+	v, err := fieldValue(value, bitwidth)
+	if err != nil {
+		return nil, fmt.Errorf("match field %s: %v", mf.GetName(), err)
+	}
+	return &p4.FieldMatch{
+		FieldId: mf.GetId(),
+		FieldMatchType: &p4.FieldMatch_Lpm{Lpm: &p4.FieldMatch_LPM{
+			Value:     fixedWidthBytes(v.And(v, prefixMask(prefixLen, bitwidth)), bitwidth),
+			PrefixLen: prefixLen,
+		}},
+	}, nil
+}
+
+// EncodeLpmPrefix encodes an IP prefix, e.g. from net.ParseCIDR, for an LPM match field
+func EncodeLpmPrefix(mf *p4_config_v1.MatchField, prefix *net.IPNet) (*p4.FieldMatch, error) {
+	ones, bits := prefix.Mask.Size()
+	if bits == 0 {
+		return nil, fmt.Errorf("match field %s: non-canonical mask in prefix %v", mf.GetName(), prefix)
+	}
+	if bits != int(mf.GetBitwidth()) {
+		return nil, fmt.Errorf("match field %s: %d-bit prefix %v for bit<%d>", mf.GetName(), bits, prefix, mf.GetBitwidth())
+	}
+	return EncodeLpmMatch(mf, prefix.IP, int32(ones))
+}
+
+// EncodeTernaryMatch clears the bits of value outside of mask; a zero mask is "don't care"
+func EncodeTernaryMatch(mf *p4_config_v1.MatchField, value, mask interface{}) (*p4.FieldMatch, error) {
+	if err := checkMatchType(mf, p4_config_v1.MatchField_TERNARY); err != nil {
+		return nil, err
+	}
+	bitwidth := mf.GetBitwidth()
+	v, err := fieldValue(value, bitwidth)
+	if err != nil {
+		return nil, fmt.Errorf("match field %s value: %v", mf.GetName(), err)
+	}
+	m, err := fieldValue(mask, bitwidth)
+	if err != nil {
+		return nil, fmt.Errorf("match field %s mask: %v", mf.GetName(), err)
+	}
+	if m.Sign() == 0 {
+		return nil, nil
+	}
+	return &p4.FieldMatch{
+		FieldId: mf.GetId(),
+		FieldMatchType: &p4.FieldMatch_Ternary_{Ternary: &p4.FieldMatch_Ternary{
+			Value: fixedWidthBytes(v.And(v, m), bitwidth),
+			Mask:  fixedWidthBytes(m, bitwidth),
+		}},
+	}, nil
+}
+
+// EncodeRangeMatch checks that low <= high; the full range of the field is "don't care"
+func EncodeRangeMatch(mf *p4_config_v1.MatchField, low, high interface{}) (*p4.FieldMatch, error) {
+	if err := checkMatchType(mf, p4_config_v1.MatchField_RANGE); err != nil {
+		return nil, err
+	}
+	bitwidth := mf.GetBitwidth()
+	l, err := fieldValue(low, bitwidth)
+	if err != nil {
+		return nil, fmt.Errorf("match field %s low: %v", mf.GetName(), err)
+	}
+	h, err := fieldValue(high, bitwidth)
+	if err != nil {
+		return nil, fmt.Errorf("match field %s high: %v", mf.GetName(), err)
+	}
+	if l.Cmp(h) > 0 {
+		return nil, fmt.Errorf("match field %s: low %v is greater than high %v", mf.GetName(), l, h)
+	}
+	if l.Sign() == 0 && h.Cmp(prefixMask(bitwidth, bitwidth)) == 0 {
+		return nil, nil
+	}
+	return &p4.FieldMatch{
+		FieldId: mf.GetId(),
+		FieldMatchType: &p4.FieldMatch_Range_{Range: &p4.FieldMatch_Range{
+			Low:  fixedWidthBytes(l, bitwidth),
+			High: fixedWidthBytes(h, bitwidth),
+		}},
+	}, nil
+}
+
+func checkMatchType(mf *p4_config_v1.MatchField, expected p4_config_v1.MatchField_MatchType) error {
+	if mf.GetMatchType() != expected {
+		return fmt.Errorf("match field %s is %v, not %v", mf.GetName(), mf.GetMatchType(), expected)
+	}
+	return nil
+}
+
+// fieldValue converts value to a new non-negative integer that fits in bitwidth bits
+func fieldValue(value interface{}, bitwidth int32) (*big.Int, error) {
+	var i *big.Int
+	switch v := value.(type) {
+	case net.IP:
+		ip := v.To16()
+		if bitwidth <= 32 {
+			ip = v.To4()
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("%v is not a valid address for bit<%d>", v, bitwidth)
+		}
+		i = new(big.Int).SetBytes(ip)
+	case net.IPMask:
+		i = new(big.Int).SetBytes(v)
+	case net.HardwareAddr:
+		i = new(big.Int).SetBytes(v)
+	default:
+		n, err := toBigInt(value)
+		if err != nil {
+			return nil, err
+		}
+		i = new(big.Int).Set(n)
+	}
+	if i.Sign() < 0 || i.BitLen() > int(bitwidth) {
+		return nil, fmt.Errorf("value %v does not fit in bit<%d>", value, bitwidth)
+	}
+	return i, nil
+}
+
+// prefixMask returns a bitwidth-bit mask with the first prefixLen bits set
+func prefixMask(prefixLen, bitwidth int32) *big.Int {
+	ones := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(prefixLen)), big.NewInt(1))
+	return ones.Lsh(ones, uint(bitwidth-prefixLen))
+}
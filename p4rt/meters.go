@@ -0,0 +1,190 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"context"
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// MeterConfig is a two-rate three-color meter configuration. Rates are in units per second and
+// burst sizes in units, where the unit (bytes or packets) comes from the meter spec in the P4Info.
+// Unit may be left unspecified; if it is set, it must match the meter spec.
+type MeterConfig struct {
+	Unit p4_config_v1.MeterSpec_Unit
+	CIR  int64 // committed information rate
+	CBS  int64 // committed burst size
+	PIR  int64 // peak information rate
+	PBS  int64 // peak burst size
+}
+
+func (m MeterConfig) toProto() *p4.MeterConfig {
+	return &p4.MeterConfig{
+		Cir:    m.CIR,
+		Cburst: m.CBS,
+		Pir:    m.PIR,
+		Pburst: m.PBS,
+	}
+}
+
+func meterConfigFromProto(config *p4.MeterConfig, unit p4_config_v1.MeterSpec_Unit) MeterConfig {
+	return MeterConfig{
+		Unit: unit,
+		CIR:  config.GetCir(),
+		CBS:  config.GetCburst(),
+		PIR:  config.GetPir(),
+		PBS:  config.GetPburst(),
+	}
+}
+
+func (m MeterConfig) validate(name string, spec *p4_config_v1.MeterSpec) error {
+	if m.Unit != p4_config_v1.MeterSpec_UNSPECIFIED && spec.GetUnit() != p4_config_v1.MeterSpec_UNSPECIFIED &&
+		m.Unit != spec.GetUnit() {
+		return fmt.Errorf("meter %s is measured in %v, not %v", name, spec.GetUnit(), m.Unit)
+	}
+	if m.CIR < 0 || m.CBS < 0 || m.PIR < 0 || m.PBS < 0 {
+		return fmt.Errorf("meter %s config has negative values: %+v", name, m)
+	}
+	if m.PIR < m.CIR {
+		return fmt.Errorf("meter %s peak rate %d is lower than committed rate %d", name, m.PIR, m.CIR)
+	}
+	return nil
+}
+
+func (c *p4rtClient) findMeter(name string) (*p4_config_v1.Meter, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.Meter(name)
+}
+
+func (c *p4rtClient) findDirectMeter(tableId uint32) (*p4_config_v1.DirectMeter, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.DirectMeterForTable(tableId)
+}
+
+func (c *p4rtClient) WriteMeter(meterName string, index int64, config MeterConfig) <-chan *p4.Error {
+	meter, err := c.findMeter(meterName)
+	if err != nil {
+		return failedWrite(localError(codes.NotFound, "%v", err))
+	}
+	if index < 0 || index >= meter.GetSize() {
+		return failedWrite(localError(codes.OutOfRange, "index %d is out of range for meter %s (size %d)",
+			index, meterName, meter.GetSize()))
+	}
+	if err := config.validate(meterName, meter.GetSpec()); err != nil {
+		return failedWrite(localError(codes.InvalidArgument, "%v", err))
+	}
+	return c.Write(&p4.Update{
+		Type: p4.Update_MODIFY,
+		Entity: &p4.Entity{Entity: &p4.Entity_MeterEntry{MeterEntry: &p4.MeterEntry{
+			MeterId: meter.GetPreamble().GetId(),
+			Index:   &p4.Index{Index: index},
+			Config:  config.toProto(),
+		}}},
+	})
+}
+
+// WriteDirectMeter configures the direct meter of a table entry (only the match and priority are used)
+func (c *p4rtClient) WriteDirectMeter(entry *p4.TableEntry, config MeterConfig) <-chan *p4.Error {
+	meter, err := c.findDirectMeter(entry.GetTableId())
+	if err != nil {
+		return failedWrite(localError(codes.NotFound, "%v", err))
+	}
+	if err := config.validate(meter.GetPreamble().GetName(), meter.GetSpec()); err != nil {
+		return failedWrite(localError(codes.InvalidArgument, "%v", err))
+	}
+	return c.Write(&p4.Update{
+		Type: p4.Update_MODIFY,
+		Entity: &p4.Entity{Entity: &p4.Entity_DirectMeterEntry{DirectMeterEntry: &p4.DirectMeterEntry{
+			TableEntry: &p4.TableEntry{
+				TableId:  entry.GetTableId(),
+				Match:    entry.GetMatch(),
+				Priority: entry.GetPriority(),
+			},
+			Config: config.toProto(),
+		}}},
+	})
+}
+
+func (c *p4rtClient) ReadMeter(ctx context.Context, meterName string, index int64) (MeterConfig, error) {
+	configs, err := c.readMeters(ctx, meterName, &p4.Index{Index: index})
+	if err != nil {
+		return MeterConfig{}, err
+	}
+	config, ok := configs[index]
+	if !ok || len(configs) != 1 {
+		return MeterConfig{}, fmt.Errorf("expected 1 entry for meter %s[%d], got %d", meterName, index, len(configs))
+	}
+	return config, nil
+}
+
+// ReadMeters reads the configuration of all indices of a meter
+func (c *p4rtClient) ReadMeters(ctx context.Context, meterName string) (map[int64]MeterConfig, error) {
+	return c.readMeters(ctx, meterName, nil)
+}
+
+func (c *p4rtClient) readMeters(ctx context.Context, meterName string, index *p4.Index) (map[int64]MeterConfig, error) {
+	meter, err := c.findMeter(meterName)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_MeterEntry{
+		MeterEntry: &p4.MeterEntry{MeterId: meter.GetPreamble().GetId(), Index: index},
+	}}).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[int64]MeterConfig, len(entities))
+	for _, entity := range entities {
+		if entry := entity.GetMeterEntry(); entry != nil {
+			configs[entry.GetIndex().GetIndex()] = meterConfigFromProto(entry.GetConfig(), meter.GetSpec().GetUnit())
+		}
+	}
+	return configs, nil
+}
+
+// ReadDirectMeter reads the direct meter configuration of a table entry
+func (c *p4rtClient) ReadDirectMeter(ctx context.Context, entry *p4.TableEntry) (MeterConfig, error) {
+	meter, err := c.findDirectMeter(entry.GetTableId())
+	if err != nil {
+		return MeterConfig{}, err
+	}
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_DirectMeterEntry{
+		DirectMeterEntry: &p4.DirectMeterEntry{TableEntry: &p4.TableEntry{
+			TableId:  entry.GetTableId(),
+			Match:    entry.GetMatch(),
+			Priority: entry.GetPriority(),
+		}},
+	}}).ReadAll()
+	if err != nil {
+		return MeterConfig{}, err
+	}
+	if len(entities) != 1 || entities[0].GetDirectMeterEntry() == nil {
+		return MeterConfig{}, fmt.Errorf("expected 1 direct meter entry for table %d, got %d",
+			entry.GetTableId(), len(entities))
+	}
+	return meterConfigFromProto(entities[0].GetDirectMeterEntry().GetConfig(), meter.GetSpec().GetUnit()), nil
+}
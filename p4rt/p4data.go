@@ -0,0 +1,466 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"math/big"
+)
+
+// P4Data values are represented with native Go types:
+//   bit<W>, int<W>, varbit<W>      *big.Int (EncodeP4Data also accepts Go integers and []byte)
+//   bool                           bool
+//   struct, header                 map[string]interface{} keyed by member name (nil for an invalid header)
+//   tuple, header stack            []interface{}
+//   enum, error                    string
+//   serializable enum              string (member name), or *big.Int if the value has no member
+//   translated type                string (sdn_string) or *big.Int (sdn_bitwidth)
+
+// EncodeP4Data encodes a Go value as P4Data according to spec; typeInfo resolves named types
+func EncodeP4Data(value interface{}, spec *p4_config_v1.P4DataTypeSpec, typeInfo *p4_config_v1.P4TypeInfo) (*p4.P4Data, error) {
+	switch s := spec.GetTypeSpec().(type) {
+	case *p4_config_v1.P4DataTypeSpec_Bitstring:
+		return encodeBitstringLike(value, s.Bitstring)
+	case *p4_config_v1.P4DataTypeSpec_Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected bool, got %T", value)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Bool{Bool: b}}, nil
+	case *p4_config_v1.P4DataTypeSpec_Tuple:
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected []interface{} for tuple, got %T", value)
+		}
+		members := s.Tuple.GetMembers()
+		if len(values) != len(members) {
+			return nil, fmt.Errorf("tuple has %d members, got %d values", len(members), len(values))
+		}
+		tuple := &p4.P4StructLike{}
+		for i, member := range members {
+			data, err := EncodeP4Data(values[i], member, typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("tuple member %d: %v", i, err)
+			}
+			tuple.Members = append(tuple.Members, data)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Tuple{Tuple: tuple}}, nil
+	case *p4_config_v1.P4DataTypeSpec_Struct:
+		structSpec, ok := typeInfo.GetStructs()[s.Struct.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown struct type %s", s.Struct.GetName())
+		}
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected map[string]interface{} for struct %s, got %T", s.Struct.GetName(), value)
+		}
+		if len(values) != len(structSpec.GetMembers()) {
+			return nil, fmt.Errorf("struct %s has %d members, got %d values",
+				s.Struct.GetName(), len(structSpec.GetMembers()), len(values))
+		}
+		data := &p4.P4StructLike{}
+		for _, member := range structSpec.GetMembers() {
+			v, ok := values[member.GetName()]
+			if !ok {
+				return nil, fmt.Errorf("missing member %s of struct %s", member.GetName(), s.Struct.GetName())
+			}
+			memberData, err := EncodeP4Data(v, member.GetTypeSpec(), typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("struct %s member %s: %v", s.Struct.GetName(), member.GetName(), err)
+			}
+			data.Members = append(data.Members, memberData)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Struct{Struct: data}}, nil
+	case *p4_config_v1.P4DataTypeSpec_Header:
+		header, err := encodeHeader(value, s.Header.GetName(), typeInfo)
+		if err != nil {
+			return nil, err
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Header{Header: header}}, nil
+	case *p4_config_v1.P4DataTypeSpec_HeaderStack:
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected []interface{} for header stack, got %T", value)
+		}
+		if len(values) != int(s.HeaderStack.GetSize()) {
+			return nil, fmt.Errorf("header stack has size %d, got %d values", s.HeaderStack.GetSize(), len(values))
+		}
+		stack := &p4.P4HeaderStack{}
+		for i, v := range values {
+			header, err := encodeHeader(v, s.HeaderStack.GetHeader().GetName(), typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("header stack entry %d: %v", i, err)
+			}
+			stack.Entries = append(stack.Entries, header)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_HeaderStack{HeaderStack: stack}}, nil
+	case *p4_config_v1.P4DataTypeSpec_Enum:
+		name, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string for enum %s, got %T", s.Enum.GetName(), value)
+		}
+		enum, ok := typeInfo.GetEnums()[s.Enum.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown enum type %s", s.Enum.GetName())
+		}
+		for _, member := range enum.GetMembers() {
+			if member.GetName() == name {
+				return &p4.P4Data{Data: &p4.P4Data_Enum{Enum: name}}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s is not a member of enum %s", name, s.Enum.GetName())
+	case *p4_config_v1.P4DataTypeSpec_Error:
+		name, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string for error, got %T", value)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Error{Error: name}}, nil
+	case *p4_config_v1.P4DataTypeSpec_SerializableEnum:
+		enum, ok := typeInfo.GetSerializableEnums()[s.SerializableEnum.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown serializable enum type %s", s.SerializableEnum.GetName())
+		}
+		if name, ok := value.(string); ok {
+			for _, member := range enum.GetMembers() {
+				if member.GetName() == name {
+					return &p4.P4Data{Data: &p4.P4Data_EnumValue{EnumValue: member.GetValue()}}, nil
+				}
+			}
+			return nil, fmt.Errorf("%s is not a member of enum %s", name, s.SerializableEnum.GetName())
+		}
+		b, err := encodeBitstring(value, enum.GetUnderlyingType().GetBitwidth(), false)
+		if err != nil {
+			return nil, fmt.Errorf("enum %s: %v", s.SerializableEnum.GetName(), err)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_EnumValue{EnumValue: b}}, nil
+	case *p4_config_v1.P4DataTypeSpec_NewType:
+		newType, ok := typeInfo.GetNewTypes()[s.NewType.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", s.NewType.GetName())
+		}
+		if original := newType.GetOriginalType(); original != nil {
+			return EncodeP4Data(value, original, typeInfo)
+		}
+		translated := newType.GetTranslatedType()
+		if translated.GetSdnString() != nil {
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string for type %s, got %T", s.NewType.GetName(), value)
+			}
+			return &p4.P4Data{Data: &p4.P4Data_Bitstring{Bitstring: []byte(str)}}, nil
+		}
+		b, err := encodeBitstring(value, translated.GetSdnBitwidth(), false)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: %v", s.NewType.GetName(), err)
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Bitstring{Bitstring: b}}, nil
+	}
+	return nil, fmt.Errorf("unsupported type spec %v", spec)
+}
+
+// DecodeP4Data decodes P4Data into a Go value according to spec; typeInfo resolves named types
+func DecodeP4Data(data *p4.P4Data, spec *p4_config_v1.P4DataTypeSpec, typeInfo *p4_config_v1.P4TypeInfo) (interface{}, error) {
+	switch s := spec.GetTypeSpec().(type) {
+	case *p4_config_v1.P4DataTypeSpec_Bitstring:
+		switch d := data.GetData().(type) {
+		case *p4.P4Data_Bitstring:
+			if i := s.Bitstring.GetInt(); i != nil {
+				return decodeSigned(d.Bitstring, i.GetBitwidth()), nil
+			}
+			return new(big.Int).SetBytes(d.Bitstring), nil
+		case *p4.P4Data_Varbit:
+			return new(big.Int).SetBytes(d.Varbit.GetBitstring()), nil
+		}
+		return nil, fmt.Errorf("expected bitstring, got %T", data.GetData())
+	case *p4_config_v1.P4DataTypeSpec_Bool:
+		d, ok := data.GetData().(*p4.P4Data_Bool)
+		if !ok {
+			return nil, fmt.Errorf("expected bool, got %T", data.GetData())
+		}
+		return d.Bool, nil
+	case *p4_config_v1.P4DataTypeSpec_Tuple:
+		d, ok := data.GetData().(*p4.P4Data_Tuple)
+		if !ok {
+			return nil, fmt.Errorf("expected tuple, got %T", data.GetData())
+		}
+		members := s.Tuple.GetMembers()
+		if len(d.Tuple.GetMembers()) != len(members) {
+			return nil, fmt.Errorf("tuple has %d members, got %d", len(members), len(d.Tuple.GetMembers()))
+		}
+		values := make([]interface{}, len(members))
+		for i, member := range members {
+			v, err := DecodeP4Data(d.Tuple.GetMembers()[i], member, typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("tuple member %d: %v", i, err)
+			}
+			values[i] = v
+		}
+		return values, nil
+	case *p4_config_v1.P4DataTypeSpec_Struct:
+		d, ok := data.GetData().(*p4.P4Data_Struct)
+		if !ok {
+			return nil, fmt.Errorf("expected struct, got %T", data.GetData())
+		}
+		structSpec, ok := typeInfo.GetStructs()[s.Struct.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown struct type %s", s.Struct.GetName())
+		}
+		if len(d.Struct.GetMembers()) != len(structSpec.GetMembers()) {
+			return nil, fmt.Errorf("struct %s has %d members, got %d",
+				s.Struct.GetName(), len(structSpec.GetMembers()), len(d.Struct.GetMembers()))
+		}
+		values := make(map[string]interface{}, len(structSpec.GetMembers()))
+		for i, member := range structSpec.GetMembers() {
+			v, err := DecodeP4Data(d.Struct.GetMembers()[i], member.GetTypeSpec(), typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("struct %s member %s: %v", s.Struct.GetName(), member.GetName(), err)
+			}
+			values[member.GetName()] = v
+		}
+		return values, nil
+	case *p4_config_v1.P4DataTypeSpec_Header:
+		d, ok := data.GetData().(*p4.P4Data_Header)
+		if !ok {
+			return nil, fmt.Errorf("expected header, got %T", data.GetData())
+		}
+		return decodeHeader(d.Header, s.Header.GetName(), typeInfo)
+	case *p4_config_v1.P4DataTypeSpec_HeaderStack:
+		d, ok := data.GetData().(*p4.P4Data_HeaderStack)
+		if !ok {
+			return nil, fmt.Errorf("expected header stack, got %T", data.GetData())
+		}
+		values := make([]interface{}, len(d.HeaderStack.GetEntries()))
+		for i, entry := range d.HeaderStack.GetEntries() {
+			v, err := decodeHeader(entry, s.HeaderStack.GetHeader().GetName(), typeInfo)
+			if err != nil {
+				return nil, fmt.Errorf("header stack entry %d: %v", i, err)
+			}
+			values[i] = v
+		}
+		return values, nil
+	case *p4_config_v1.P4DataTypeSpec_Enum:
+		d, ok := data.GetData().(*p4.P4Data_Enum)
+		if !ok {
+			return nil, fmt.Errorf("expected enum, got %T", data.GetData())
+		}
+		return d.Enum, nil
+	case *p4_config_v1.P4DataTypeSpec_Error:
+		d, ok := data.GetData().(*p4.P4Data_Error)
+		if !ok {
+			return nil, fmt.Errorf("expected error, got %T", data.GetData())
+		}
+		return d.Error, nil
+	case *p4_config_v1.P4DataTypeSpec_SerializableEnum:
+		d, ok := data.GetData().(*p4.P4Data_EnumValue)
+		if !ok {
+			return nil, fmt.Errorf("expected enum value, got %T", data.GetData())
+		}
+		value := new(big.Int).SetBytes(d.EnumValue)
+		for _, member := range typeInfo.GetSerializableEnums()[s.SerializableEnum.GetName()].GetMembers() {
+			if new(big.Int).SetBytes(member.GetValue()).Cmp(value) == 0 {
+				return member.GetName(), nil
+			}
+		}
+		return value, nil
+	case *p4_config_v1.P4DataTypeSpec_NewType:
+		newType, ok := typeInfo.GetNewTypes()[s.NewType.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", s.NewType.GetName())
+		}
+		if original := newType.GetOriginalType(); original != nil {
+			return DecodeP4Data(data, original, typeInfo)
+		}
+		d, ok := data.GetData().(*p4.P4Data_Bitstring)
+		if !ok {
+			return nil, fmt.Errorf("expected bitstring for type %s, got %T", s.NewType.GetName(), data.GetData())
+		}
+		if newType.GetTranslatedType().GetSdnString() != nil {
+			return string(d.Bitstring), nil
+		}
+		return new(big.Int).SetBytes(d.Bitstring), nil
+	}
+	return nil, fmt.Errorf("unsupported type spec %v", spec)
+}
+
+func encodeBitstringLike(value interface{}, spec *p4_config_v1.P4BitstringLikeTypeSpec) (*p4.P4Data, error) {
+	switch s := spec.GetTypeSpec().(type) {
+	case *p4_config_v1.P4BitstringLikeTypeSpec_Bit:
+		b, err := encodeBitstring(value, s.Bit.GetBitwidth(), false)
+		if err != nil {
+			return nil, err
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Bitstring{Bitstring: b}}, nil
+	case *p4_config_v1.P4BitstringLikeTypeSpec_Int:
+		b, err := encodeBitstring(value, s.Int.GetBitwidth(), true)
+		if err != nil {
+			return nil, err
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Bitstring{Bitstring: b}}, nil
+	case *p4_config_v1.P4BitstringLikeTypeSpec_Varbit:
+		i, err := toBigInt(value)
+		if err != nil {
+			return nil, err
+		}
+		if i.Sign() < 0 || i.BitLen() > int(s.Varbit.GetMaxBitwidth()) {
+			return nil, fmt.Errorf("value %v does not fit in varbit<%d>", i, s.Varbit.GetMaxBitwidth())
+		}
+		return &p4.P4Data{Data: &p4.P4Data_Varbit{Varbit: &p4.P4Varbit{
+			Bitstring: fixedWidthBytes(i, int32(i.BitLen())),
+			Bitwidth:  int32(i.BitLen()),
+		}}}, nil
+	}
+	return nil, fmt.Errorf("unsupported bitstring type spec %v", spec)
+}
+
+func encodeHeader(value interface{}, headerName string, typeInfo *p4_config_v1.P4TypeInfo) (*p4.P4Header, error) {
+	headerSpec, ok := typeInfo.GetHeaders()[headerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown header type %s", headerName)
+	}
+	values, ok := value.(map[string]interface{})
+	if value != nil && !ok {
+		return nil, fmt.Errorf("expected map[string]interface{} for header %s, got %T", headerName, value)
+	}
+	if values == nil {
+		return &p4.P4Header{IsValid: false}, nil
+	}
+	header := &p4.P4Header{IsValid: true}
+	for _, member := range headerSpec.GetMembers() {
+		// P4Header members are plain bitstrings, which cannot carry a varbit's bitwidth
+		if member.GetTypeSpec().GetVarbit() != nil {
+			return nil, fmt.Errorf("header %s member %s: varbit members are not supported", headerName, member.GetName())
+		}
+		v, ok := values[member.GetName()]
+		if !ok {
+			return nil, fmt.Errorf("missing member %s of header %s", member.GetName(), headerName)
+		}
+		data, err := encodeBitstringLike(v, member.GetTypeSpec())
+		if err != nil {
+			return nil, fmt.Errorf("header %s member %s: %v", headerName, member.GetName(), err)
+		}
+		header.Bitstrings = append(header.Bitstrings, data.GetBitstring())
+	}
+	return header, nil
+}
+
+func decodeHeader(header *p4.P4Header, headerName string, typeInfo *p4_config_v1.P4TypeInfo) (map[string]interface{}, error) {
+	if !header.GetIsValid() {
+		return nil, nil
+	}
+	headerSpec, ok := typeInfo.GetHeaders()[headerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown header type %s", headerName)
+	}
+	if len(header.GetBitstrings()) != len(headerSpec.GetMembers()) {
+		return nil, fmt.Errorf("header %s has %d members, got %d",
+			headerName, len(headerSpec.GetMembers()), len(header.GetBitstrings()))
+	}
+	values := make(map[string]interface{}, len(headerSpec.GetMembers()))
+	for i, member := range headerSpec.GetMembers() {
+		if member.GetTypeSpec().GetVarbit() != nil {
+			return nil, fmt.Errorf("header %s member %s: varbit members are not supported", headerName, member.GetName())
+		}
+		if n := member.GetTypeSpec().GetInt(); n != nil {
+			values[member.GetName()] = decodeSigned(header.GetBitstrings()[i], n.GetBitwidth())
+		} else {
+			values[member.GetName()] = new(big.Int).SetBytes(header.GetBitstrings()[i])
+		}
+	}
+	return values, nil
+}
+
+// encodeBitstring encodes an integer value into ceil(bitwidth/8) bytes; signed values use two's complement
+func encodeBitstring(value interface{}, bitwidth int32, signed bool) ([]byte, error) {
+	if b, ok := value.([]byte); ok {
+		if err := validateBytes(b, bitwidth); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	i, err := toBigInt(value)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(bitwidth-1))
+		if i.Cmp(limit) >= 0 || i.Cmp(new(big.Int).Neg(limit)) < 0 {
+			return nil, fmt.Errorf("value %v does not fit in int<%d>", i, bitwidth)
+		}
+		if i.Sign() < 0 {
+			i = new(big.Int).Add(i, new(big.Int).Lsh(big.NewInt(1), uint(bitwidth)))
+		}
+	} else if i.Sign() < 0 || i.BitLen() > int(bitwidth) {
+		return nil, fmt.Errorf("value %v does not fit in bit<%d>", i, bitwidth)
+	}
+	return fixedWidthBytes(i, bitwidth), nil
+}
+
+func decodeSigned(b []byte, bitwidth int32) *big.Int {
+	i := new(big.Int).SetBytes(b)
+	if bitwidth > 0 && i.Bit(int(bitwidth-1)) == 1 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(bitwidth)))
+	}
+	return i
+}
+
+// fixedWidthBytes returns the big-endian bytes of a non-negative value, zero-padded to ceil(bitwidth/8) bytes
+func fixedWidthBytes(i *big.Int, bitwidth int32) []byte {
+	size := int(bitwidth+7) / 8
+	if size == 0 {
+		size = 1
+	}
+	value := i.Bytes()
+	if len(value) >= size {
+		return value
+	}
+	b := make([]byte, size)
+	copy(b[size-len(value):], value)
+	return b
+}
+
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case *big.Int:
+		return v, nil
+	case int:
+		return big.NewInt(int64(v)), nil
+	case int8:
+		return big.NewInt(int64(v)), nil
+	case int16:
+		return big.NewInt(int64(v)), nil
+	case int32:
+		return big.NewInt(int64(v)), nil
+	case int64:
+		return big.NewInt(v), nil
+	case uint:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint16:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint32:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint64:
+		return new(big.Int).SetUint64(v), nil
+	case []byte:
+		return new(big.Int).SetBytes(v), nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to an integer", value)
+}
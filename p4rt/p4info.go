@@ -0,0 +1,413 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	"sort"
+	"strings"
+)
+
+// P4InfoIndex provides lookups of P4Info objects by id, fully-qualified name, alias
+// or unique dotted suffix of the name (e.g. "forwarding.mpls")
+type P4InfoIndex struct {
+	p4info         *p4_config_v1.P4Info
+	tables         *objectIndex
+	actions        *objectIndex
+	actionProfiles *objectIndex
+	counters       *objectIndex
+	directCounters *objectIndex
+	meters         *objectIndex
+	directMeters   *objectIndex
+	registers      *objectIndex
+	digests        *objectIndex
+	packetMetadata *objectIndex
+}
+
+func NewP4InfoIndex(p4info *p4_config_v1.P4Info) *P4InfoIndex {
+	i := &P4InfoIndex{
+		p4info:         p4info,
+		tables:         newObjectIndex("table"),
+		actions:        newObjectIndex("action"),
+		actionProfiles: newObjectIndex("action profile"),
+		counters:       newObjectIndex("counter"),
+		directCounters: newObjectIndex("direct counter"),
+		meters:         newObjectIndex("meter"),
+		directMeters:   newObjectIndex("direct meter"),
+		registers:      newObjectIndex("register"),
+		digests:        newObjectIndex("digest"),
+		packetMetadata: newObjectIndex("controller packet metadata"),
+	}
+	for _, o := range p4info.GetTables() {
+		i.tables.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetActions() {
+		i.actions.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetActionProfiles() {
+		i.actionProfiles.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetCounters() {
+		i.counters.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetDirectCounters() {
+		i.directCounters.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetMeters() {
+		i.meters.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetDirectMeters() {
+		i.directMeters.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetRegisters() {
+		i.registers.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetDigests() {
+		i.digests.add(o.GetPreamble(), o)
+	}
+	for _, o := range p4info.GetControllerPacketMetadata() {
+		i.packetMetadata.add(o.GetPreamble(), o)
+	}
+	return i
+}
+
+func (i *P4InfoIndex) P4Info() *p4_config_v1.P4Info {
+	return i.p4info
+}
+
+func (i *P4InfoIndex) Table(name string) (*p4_config_v1.Table, error) {
+	o, err := i.tables.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Table), nil
+}
+
+func (i *P4InfoIndex) TableById(id uint32) (*p4_config_v1.Table, error) {
+	o, err := i.tables.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Table), nil
+}
+
+func (i *P4InfoIndex) MatchField(tableName, fieldName string) (*p4_config_v1.MatchField, error) {
+	table, err := i.Table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, mf := range table.GetMatchFields() {
+		if mf.GetName() == fieldName {
+			return mf, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown match field %q in table %s", fieldName, table.GetPreamble().GetName())
+}
+
+func (i *P4InfoIndex) MatchFieldById(tableId, fieldId uint32) (*p4_config_v1.MatchField, error) {
+	table, err := i.TableById(tableId)
+	if err != nil {
+		return nil, err
+	}
+	for _, mf := range table.GetMatchFields() {
+		if mf.GetId() == fieldId {
+			return mf, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown match field id %d in table %s", fieldId, table.GetPreamble().GetName())
+}
+
+func (i *P4InfoIndex) Action(name string) (*p4_config_v1.Action, error) {
+	o, err := i.actions.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Action), nil
+}
+
+func (i *P4InfoIndex) ActionById(id uint32) (*p4_config_v1.Action, error) {
+	o, err := i.actions.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Action), nil
+}
+
+func (i *P4InfoIndex) Param(actionName, paramName string) (*p4_config_v1.Action_Param, error) {
+	action, err := i.Action(actionName)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range action.GetParams() {
+		if p.GetName() == paramName {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown param %q in action %s", paramName, action.GetPreamble().GetName())
+}
+
+func (i *P4InfoIndex) ParamById(actionId, paramId uint32) (*p4_config_v1.Action_Param, error) {
+	action, err := i.ActionById(actionId)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range action.GetParams() {
+		if p.GetId() == paramId {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown param id %d in action %s", paramId, action.GetPreamble().GetName())
+}
+
+func (i *P4InfoIndex) ActionProfile(name string) (*p4_config_v1.ActionProfile, error) {
+	o, err := i.actionProfiles.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.ActionProfile), nil
+}
+
+func (i *P4InfoIndex) ActionProfileById(id uint32) (*p4_config_v1.ActionProfile, error) {
+	o, err := i.actionProfiles.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.ActionProfile), nil
+}
+
+func (i *P4InfoIndex) Counter(name string) (*p4_config_v1.Counter, error) {
+	o, err := i.counters.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Counter), nil
+}
+
+func (i *P4InfoIndex) CounterById(id uint32) (*p4_config_v1.Counter, error) {
+	o, err := i.counters.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Counter), nil
+}
+
+func (i *P4InfoIndex) DirectCounter(name string) (*p4_config_v1.DirectCounter, error) {
+	o, err := i.directCounters.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.DirectCounter), nil
+}
+
+func (i *P4InfoIndex) DirectCounterById(id uint32) (*p4_config_v1.DirectCounter, error) {
+	o, err := i.directCounters.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.DirectCounter), nil
+}
+
+func (i *P4InfoIndex) Meter(name string) (*p4_config_v1.Meter, error) {
+	o, err := i.meters.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Meter), nil
+}
+
+func (i *P4InfoIndex) MeterById(id uint32) (*p4_config_v1.Meter, error) {
+	o, err := i.meters.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Meter), nil
+}
+
+func (i *P4InfoIndex) DirectMeter(name string) (*p4_config_v1.DirectMeter, error) {
+	o, err := i.directMeters.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.DirectMeter), nil
+}
+
+func (i *P4InfoIndex) DirectMeterById(id uint32) (*p4_config_v1.DirectMeter, error) {
+	o, err := i.directMeters.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.DirectMeter), nil
+}
+
+// DirectCounterForTable returns the direct counter attached to a table
+func (i *P4InfoIndex) DirectCounterForTable(tableId uint32) (*p4_config_v1.DirectCounter, error) {
+	table, err := i.TableById(tableId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range table.GetDirectResourceIds() {
+		if o, ok := i.directCounters.byId[id]; ok {
+			return o.(*p4_config_v1.DirectCounter), nil
+		}
+	}
+	return nil, fmt.Errorf("table %s has no direct counter", table.GetPreamble().GetName())
+}
+
+// DirectMeterForTable returns the direct meter attached to a table
+func (i *P4InfoIndex) DirectMeterForTable(tableId uint32) (*p4_config_v1.DirectMeter, error) {
+	table, err := i.TableById(tableId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range table.GetDirectResourceIds() {
+		if o, ok := i.directMeters.byId[id]; ok {
+			return o.(*p4_config_v1.DirectMeter), nil
+		}
+	}
+	return nil, fmt.Errorf("table %s has no direct meter", table.GetPreamble().GetName())
+}
+
+func (i *P4InfoIndex) Register(name string) (*p4_config_v1.Register, error) {
+	o, err := i.registers.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Register), nil
+}
+
+func (i *P4InfoIndex) RegisterById(id uint32) (*p4_config_v1.Register, error) {
+	o, err := i.registers.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Register), nil
+}
+
+func (i *P4InfoIndex) Digest(name string) (*p4_config_v1.Digest, error) {
+	o, err := i.digests.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Digest), nil
+}
+
+func (i *P4InfoIndex) DigestById(id uint32) (*p4_config_v1.Digest, error) {
+	o, err := i.digests.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.Digest), nil
+}
+
+// ControllerPacketMetadata returns the controller header, e.g. "packet_in" or "packet_out"
+func (i *P4InfoIndex) ControllerPacketMetadata(name string) (*p4_config_v1.ControllerPacketMetadata, error) {
+	o, err := i.packetMetadata.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.ControllerPacketMetadata), nil
+}
+
+func (i *P4InfoIndex) ControllerPacketMetadataById(id uint32) (*p4_config_v1.ControllerPacketMetadata, error) {
+	o, err := i.packetMetadata.lookupId(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*p4_config_v1.ControllerPacketMetadata), nil
+}
+
+func (i *P4InfoIndex) PacketMetadata(headerName, fieldName string) (*p4_config_v1.ControllerPacketMetadata_Metadata, error) {
+	header, err := i.ControllerPacketMetadata(headerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range header.GetMetadata() {
+		if m.GetName() == fieldName {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown metadata %q in %s", fieldName, header.GetPreamble().GetName())
+}
+
+// objectIndex indexes the P4Info objects of one kind by the fields of their preamble
+type objectIndex struct {
+	kind    string
+	byId    map[uint32]interface{}
+	byName  map[string][]interface{}
+	byAlias map[string][]interface{}
+	names   map[interface{}]string
+}
+
+func newObjectIndex(kind string) *objectIndex {
+	return &objectIndex{
+		kind:    kind,
+		byId:    make(map[uint32]interface{}),
+		byName:  make(map[string][]interface{}),
+		byAlias: make(map[string][]interface{}),
+		names:   make(map[interface{}]string),
+	}
+}
+
+func (i *objectIndex) add(preamble *p4_config_v1.Preamble, o interface{}) {
+	i.byId[preamble.GetId()] = o
+	i.byName[preamble.GetName()] = append(i.byName[preamble.GetName()], o)
+	if alias := preamble.GetAlias(); alias != "" && alias != preamble.GetName() {
+		i.byAlias[alias] = append(i.byAlias[alias], o)
+	}
+	i.names[o] = preamble.GetName()
+}
+
+func (i *objectIndex) lookupId(id uint32) (interface{}, error) {
+	if o, ok := i.byId[id]; ok {
+		return o, nil
+	}
+	return nil, fmt.Errorf("unknown %s id %d", i.kind, id)
+}
+
+// lookup tries the fully-qualified name, then the alias, then a unique dotted suffix of the name
+func (i *objectIndex) lookup(name string) (interface{}, error) {
+	if matches := i.byName[name]; len(matches) > 0 {
+		return i.unique(name, matches)
+	}
+	if matches := i.byAlias[name]; len(matches) > 0 {
+		return i.unique(name, matches)
+	}
+	var matches []interface{}
+	for fullName, objects := range i.byName {
+		if strings.HasSuffix(fullName, "."+name) {
+			matches = append(matches, objects...)
+		}
+	}
+	if len(matches) > 0 {
+		return i.unique(name, matches)
+	}
+	return nil, fmt.Errorf("unknown %s %q", i.kind, name)
+}
+
+func (i *objectIndex) unique(name string, matches []interface{}) (interface{}, error) {
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for j, o := range matches {
+		names[j] = i.names[o]
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("ambiguous %s %q matches %s", i.kind, name, strings.Join(names, ", "))
+}
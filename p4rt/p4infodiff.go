@@ -0,0 +1,422 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+)
+
+type P4InfoChangeKind int
+
+const (
+	ObjectAdded P4InfoChangeKind = iota
+	ObjectRemoved
+	ObjectRenamed // same id, new name
+	IdChanged     // same name, new id
+	BitwidthChanged
+	MatchTypeChanged
+	SizeChanged
+	ActionRefAdded
+	ActionRefRemoved
+)
+
+func (k P4InfoChangeKind) String() string {
+	switch k {
+	case ObjectAdded:
+		return "added"
+	case ObjectRemoved:
+		return "removed"
+	case ObjectRenamed:
+		return "renamed"
+	case IdChanged:
+		return "id changed"
+	case BitwidthChanged:
+		return "bitwidth changed"
+	case MatchTypeChanged:
+		return "match type changed"
+	case SizeChanged:
+		return "size changed"
+	case ActionRefAdded:
+		return "action added"
+	case ActionRefRemoved:
+		return "action removed"
+	}
+	return fmt.Sprintf("P4InfoChangeKind(%d)", int(k))
+}
+
+// P4InfoChange is a difference between two P4Infos. Breaking is set for changes that
+// invalidate entries written for the old P4Info (e.g. removed or resized fields, new ids).
+type P4InfoChange struct {
+	Kind     P4InfoChangeKind
+	Object   string // e.g. "table", "match field", "param"
+	Name     string // name in the old P4Info (new P4Info for additions); fields are prefixed by their table or action
+	NewName  string // for renames
+	OldValue int64  // id, bitwidth, match type or size, according to Kind
+	NewValue int64
+	Breaking bool
+}
+
+func (c P4InfoChange) String() string {
+	var s string
+	switch c.Kind {
+	case ObjectAdded, ObjectRemoved, ActionRefAdded, ActionRefRemoved:
+		s = fmt.Sprintf("%s %s: %v", c.Object, c.Name, c.Kind)
+	case ObjectRenamed:
+		s = fmt.Sprintf("%s %s: renamed to %s", c.Object, c.Name, c.NewName)
+	case MatchTypeChanged:
+		s = fmt.Sprintf("%s %s: match type changed from %v to %v", c.Object, c.Name,
+			p4_config_v1.MatchField_MatchType(c.OldValue), p4_config_v1.MatchField_MatchType(c.NewValue))
+	default:
+		s = fmt.Sprintf("%s %s: %v from %d to %d", c.Object, c.Name, c.Kind, c.OldValue, c.NewValue)
+	}
+	if c.Breaking {
+		s += " (breaking)"
+	}
+	return s
+}
+
+// DiffP4Info compares the objects of two P4Infos. Objects are paired by name, then by id,
+// so an object with the same id and a new name is reported as renamed.
+func DiffP4Info(oldInfo, newInfo *p4_config_v1.P4Info) []P4InfoChange {
+	d := &p4infoDiff{}
+
+	oldTables, newTables := map[uint32]*p4_config_v1.Table{}, map[uint32]*p4_config_v1.Table{}
+	for _, t := range oldInfo.GetTables() {
+		oldTables[t.GetPreamble().GetId()] = t
+	}
+	for _, t := range newInfo.GetTables() {
+		newTables[t.GetPreamble().GetId()] = t
+	}
+	d.objects("table", preambles(oldInfo.GetTables()), preambles(newInfo.GetTables()), func(o, n *p4_config_v1.Preamble) {
+		d.table(oldTables[o.GetId()], newTables[n.GetId()], oldInfo, newInfo)
+	})
+
+	oldActions, newActions := map[uint32]*p4_config_v1.Action{}, map[uint32]*p4_config_v1.Action{}
+	for _, a := range oldInfo.GetActions() {
+		oldActions[a.GetPreamble().GetId()] = a
+	}
+	for _, a := range newInfo.GetActions() {
+		newActions[a.GetPreamble().GetId()] = a
+	}
+	d.objects("action", preambles(oldInfo.GetActions()), preambles(newInfo.GetActions()), func(o, n *p4_config_v1.Preamble) {
+		d.action(oldActions[o.GetId()], newActions[n.GetId()])
+	})
+
+	sizes := func(kind string, oldObjects, newObjects interface{}, size func(interface{}) int64) {
+		oldById, newById := map[uint32]interface{}{}, map[uint32]interface{}{}
+		oldPreambles, newPreambles := preambles(oldObjects), preambles(newObjects)
+		for i, o := range objectList(oldObjects) {
+			oldById[oldPreambles[i].GetId()] = o
+		}
+		for i, o := range objectList(newObjects) {
+			newById[newPreambles[i].GetId()] = o
+		}
+		d.objects(kind, oldPreambles, newPreambles, func(o, n *p4_config_v1.Preamble) {
+			oldSize, newSize := size(oldById[o.GetId()]), size(newById[n.GetId()])
+			if oldSize != newSize {
+				d.add(P4InfoChange{Kind: SizeChanged, Object: kind, Name: o.GetName(),
+					OldValue: oldSize, NewValue: newSize, Breaking: newSize < oldSize})
+			}
+		})
+	}
+	sizes("action profile", oldInfo.GetActionProfiles(), newInfo.GetActionProfiles(), func(o interface{}) int64 {
+		return o.(*p4_config_v1.ActionProfile).GetSize()
+	})
+	sizes("counter", oldInfo.GetCounters(), newInfo.GetCounters(), func(o interface{}) int64 {
+		return o.(*p4_config_v1.Counter).GetSize()
+	})
+	sizes("meter", oldInfo.GetMeters(), newInfo.GetMeters(), func(o interface{}) int64 {
+		return o.(*p4_config_v1.Meter).GetSize()
+	})
+	sizes("register", oldInfo.GetRegisters(), newInfo.GetRegisters(), func(o interface{}) int64 {
+		return int64(o.(*p4_config_v1.Register).GetSize())
+	})
+	d.objects("direct counter", preambles(oldInfo.GetDirectCounters()), preambles(newInfo.GetDirectCounters()), nil)
+	d.objects("direct meter", preambles(oldInfo.GetDirectMeters()), preambles(newInfo.GetDirectMeters()), nil)
+	d.objects("digest", preambles(oldInfo.GetDigests()), preambles(newInfo.GetDigests()), nil)
+
+	oldMetadata, newMetadata := map[uint32]*p4_config_v1.ControllerPacketMetadata{}, map[uint32]*p4_config_v1.ControllerPacketMetadata{}
+	for _, m := range oldInfo.GetControllerPacketMetadata() {
+		oldMetadata[m.GetPreamble().GetId()] = m
+	}
+	for _, m := range newInfo.GetControllerPacketMetadata() {
+		newMetadata[m.GetPreamble().GetId()] = m
+	}
+	d.objects("controller packet metadata", preambles(oldInfo.GetControllerPacketMetadata()), preambles(newInfo.GetControllerPacketMetadata()),
+		func(o, n *p4_config_v1.Preamble) {
+			d.fields("metadata", o.GetName(), metadataFields(oldMetadata[o.GetId()]), metadataFields(newMetadata[n.GetId()]))
+		})
+
+	return d.changes
+}
+
+// BreakingChanges returns the changes that invalidate entries written for the old P4Info
+func BreakingChanges(changes []P4InfoChange) []P4InfoChange {
+	var breaking []P4InfoChange
+	for _, c := range changes {
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	return breaking
+}
+
+type p4infoDiff struct {
+	changes []P4InfoChange
+}
+
+func (d *p4infoDiff) add(c P4InfoChange) {
+	d.changes = append(d.changes, c)
+}
+
+// objects reports added, removed, renamed and renumbered objects and calls compare for each pair
+func (d *p4infoDiff) objects(kind string, oldObjects, newObjects []*p4_config_v1.Preamble, compare func(o, n *p4_config_v1.Preamble)) {
+	pairs, removed, added := pairByNameAndId(len(oldObjects), len(newObjects),
+		func(i int) (string, uint32) { return oldObjects[i].GetName(), oldObjects[i].GetId() },
+		func(i int) (string, uint32) { return newObjects[i].GetName(), newObjects[i].GetId() })
+	for _, p := range pairs {
+		o, n := oldObjects[p[0]], newObjects[p[1]]
+		if o.GetName() != n.GetName() {
+			d.add(P4InfoChange{Kind: ObjectRenamed, Object: kind, Name: o.GetName(), NewName: n.GetName(), Breaking: true})
+		} else if o.GetId() != n.GetId() {
+			d.add(P4InfoChange{Kind: IdChanged, Object: kind, Name: o.GetName(),
+				OldValue: int64(o.GetId()), NewValue: int64(n.GetId()), Breaking: true})
+		}
+		if compare != nil {
+			compare(o, n)
+		}
+	}
+	for _, i := range removed {
+		d.add(P4InfoChange{Kind: ObjectRemoved, Object: kind, Name: oldObjects[i].GetName(), Breaking: true})
+	}
+	for _, i := range added {
+		d.add(P4InfoChange{Kind: ObjectAdded, Object: kind, Name: newObjects[i].GetName()})
+	}
+}
+
+func (d *p4infoDiff) table(oldTable, newTable *p4_config_v1.Table, oldInfo, newInfo *p4_config_v1.P4Info) {
+	name := oldTable.GetPreamble().GetName()
+	var oldFields, newFields []p4infoField
+	for _, mf := range oldTable.GetMatchFields() {
+		oldFields = append(oldFields, p4infoField{mf.GetName(), mf.GetId(), mf.GetBitwidth(), int64(mf.GetMatchType())})
+	}
+	for _, mf := range newTable.GetMatchFields() {
+		newFields = append(newFields, p4infoField{mf.GetName(), mf.GetId(), mf.GetBitwidth(), int64(mf.GetMatchType())})
+	}
+	d.fields("match field", name, oldFields, newFields)
+
+	// action refs are paired like the actions themselves, so renamed actions are not reported again
+	oldRefs, newRefs := actionRefs(oldTable, oldInfo), actionRefs(newTable, newInfo)
+	_, removed, added := pairByNameAndId(len(oldRefs), len(newRefs),
+		func(i int) (string, uint32) { return oldRefs[i].GetName(), oldRefs[i].GetId() },
+		func(i int) (string, uint32) { return newRefs[i].GetName(), newRefs[i].GetId() })
+	for _, i := range removed {
+		d.add(P4InfoChange{Kind: ActionRefRemoved, Object: "table", Name: name + ": " + oldRefs[i].GetName(), Breaking: true})
+	}
+	for _, i := range added {
+		d.add(P4InfoChange{Kind: ActionRefAdded, Object: "table", Name: name + ": " + newRefs[i].GetName()})
+	}
+
+	if oldTable.GetSize() != newTable.GetSize() {
+		d.add(P4InfoChange{Kind: SizeChanged, Object: "table", Name: name,
+			OldValue: oldTable.GetSize(), NewValue: newTable.GetSize(), Breaking: newTable.GetSize() < oldTable.GetSize()})
+	}
+}
+
+func (d *p4infoDiff) action(oldAction, newAction *p4_config_v1.Action) {
+	var oldParams, newParams []p4infoField
+	for _, p := range oldAction.GetParams() {
+		oldParams = append(oldParams, p4infoField{p.GetName(), p.GetId(), p.GetBitwidth(), 0})
+	}
+	for _, p := range newAction.GetParams() {
+		newParams = append(newParams, p4infoField{p.GetName(), p.GetId(), p.GetBitwidth(), 0})
+	}
+	d.fields("param", oldAction.GetPreamble().GetName(), oldParams, newParams)
+}
+
+// p4infoField is a match field, action param or packet metadata field
+type p4infoField struct {
+	name      string
+	id        uint32
+	bitwidth  int32
+	matchType int64
+}
+
+// fields compares the fields of an object; all changes are breaking, since existing
+// entries (or packet metadata) no longer match the new fields
+func (d *p4infoDiff) fields(kind, parent string, oldFields, newFields []p4infoField) {
+	pairs, removed, added := pairByNameAndId(len(oldFields), len(newFields),
+		func(i int) (string, uint32) { return oldFields[i].name, oldFields[i].id },
+		func(i int) (string, uint32) { return newFields[i].name, newFields[i].id })
+	for _, p := range pairs {
+		o, n := oldFields[p[0]], newFields[p[1]]
+		name := parent + "." + o.name
+		if o.name != n.name {
+			d.add(P4InfoChange{Kind: ObjectRenamed, Object: kind, Name: name, NewName: parent + "." + n.name, Breaking: true})
+		} else if o.id != n.id {
+			d.add(P4InfoChange{Kind: IdChanged, Object: kind, Name: name,
+				OldValue: int64(o.id), NewValue: int64(n.id), Breaking: true})
+		}
+		if o.bitwidth != n.bitwidth {
+			d.add(P4InfoChange{Kind: BitwidthChanged, Object: kind, Name: name,
+				OldValue: int64(o.bitwidth), NewValue: int64(n.bitwidth), Breaking: true})
+		}
+		if o.matchType != n.matchType {
+			d.add(P4InfoChange{Kind: MatchTypeChanged, Object: kind, Name: name,
+				OldValue: o.matchType, NewValue: n.matchType, Breaking: true})
+		}
+	}
+	for _, i := range removed {
+		d.add(P4InfoChange{Kind: ObjectRemoved, Object: kind, Name: parent + "." + oldFields[i].name, Breaking: true})
+	}
+	for _, i := range added {
+		d.add(P4InfoChange{Kind: ObjectAdded, Object: kind, Name: parent + "." + newFields[i].name, Breaking: true})
+	}
+}
+
+// pairByNameAndId pairs old and new objects by name, then the remaining ones by id
+func pairByNameAndId(numOld, numNew int, oldKey, newKey func(int) (string, uint32)) (pairs [][2]int, removed, added []int) {
+	newByName, newById := map[string]int{}, map[uint32]int{}
+	for i := 0; i < numNew; i++ {
+		name, id := newKey(i)
+		newByName[name] = i
+		newById[id] = i
+	}
+	paired := map[int]bool{}
+	var unpaired []int
+	for i := 0; i < numOld; i++ {
+		name, _ := oldKey(i)
+		if j, ok := newByName[name]; ok && !paired[j] {
+			pairs = append(pairs, [2]int{i, j})
+			paired[j] = true
+		} else {
+			unpaired = append(unpaired, i)
+		}
+	}
+	for _, i := range unpaired {
+		_, id := oldKey(i)
+		if j, ok := newById[id]; ok && !paired[j] {
+			pairs = append(pairs, [2]int{i, j})
+			paired[j] = true
+		} else {
+			removed = append(removed, i)
+		}
+	}
+	for j := 0; j < numNew; j++ {
+		if !paired[j] {
+			added = append(added, j)
+		}
+	}
+	return
+}
+
+// actionRefs returns the preambles of the actions of a table
+func actionRefs(table *p4_config_v1.Table, p4info *p4_config_v1.P4Info) []*p4_config_v1.Preamble {
+	var refs []*p4_config_v1.Preamble
+	for _, ref := range table.GetActionRefs() {
+		preamble := &p4_config_v1.Preamble{Id: ref.GetId(), Name: fmt.Sprintf("action id %d", ref.GetId())}
+		for _, a := range p4info.GetActions() {
+			if a.GetPreamble().GetId() == ref.GetId() {
+				preamble = a.GetPreamble()
+			}
+		}
+		refs = append(refs, preamble)
+	}
+	return refs
+}
+
+func metadataFields(m *p4_config_v1.ControllerPacketMetadata) []p4infoField {
+	var fields []p4infoField
+	for _, f := range m.GetMetadata() {
+		fields = append(fields, p4infoField{f.GetName(), f.GetId(), f.GetBitwidth(), 0})
+	}
+	return fields
+}
+
+// objectList converts a slice of P4Info objects to []interface{}
+func objectList(objects interface{}) []interface{} {
+	var list []interface{}
+	switch o := objects.(type) {
+	case []*p4_config_v1.ActionProfile:
+		for _, x := range o {
+			list = append(list, x)
+		}
+	case []*p4_config_v1.Counter:
+		for _, x := range o {
+			list = append(list, x)
+		}
+	case []*p4_config_v1.Meter:
+		for _, x := range o {
+			list = append(list, x)
+		}
+	case []*p4_config_v1.Register:
+		for _, x := range o {
+			list = append(list, x)
+		}
+	}
+	return list
+}
+
+// preambles returns the preambles of a slice of P4Info objects
+func preambles(objects interface{}) []*p4_config_v1.Preamble {
+	var list []*p4_config_v1.Preamble
+	switch o := objects.(type) {
+	case []*p4_config_v1.Table:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.Action:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.ActionProfile:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.Counter:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.DirectCounter:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.Meter:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.DirectMeter:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.Register:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.Digest:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	case []*p4_config_v1.ControllerPacketMetadata:
+		for _, x := range o {
+			list = append(list, x.GetPreamble())
+		}
+	}
+	return list
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type P4InfoFormat int
+
+const (
+	// P4InfoAuto detects the format from the file extension, or else from the contents
+	P4InfoAuto P4InfoFormat = iota
+	P4InfoText
+	P4InfoBinary
+	P4InfoJSON
+)
+
+func (f P4InfoFormat) String() string {
+	switch f {
+	case P4InfoAuto:
+		return "auto"
+	case P4InfoText:
+		return "text"
+	case P4InfoBinary:
+		return "binary"
+	case P4InfoJSON:
+		return "json"
+	}
+	return fmt.Sprintf("P4InfoFormat(%d)", int(f))
+}
+
+// ParseP4InfoFormat parses a format name as printed by String, e.g. for command line flags
+func ParseP4InfoFormat(name string) (P4InfoFormat, error) {
+	for _, f := range []P4InfoFormat{P4InfoAuto, P4InfoText, P4InfoBinary, P4InfoJSON} {
+		if strings.EqualFold(name, f.String()) {
+			return f, nil
+		}
+	}
+	return P4InfoAuto, fmt.Errorf("unknown P4Info format %q (auto, text, binary or json)", name)
+}
+
+func LoadP4InfoFormat(p4infoPath string, format P4InfoFormat) (p4info p4_config_v1.P4Info, err error) {
+	fmt.Printf("P4 Info: %s\n", p4infoPath)
+
+	p4infoBytes, err := ioutil.ReadFile(p4infoPath)
+	if err != nil {
+		return
+	}
+	if format == P4InfoAuto {
+		format = formatFromPath(p4infoPath)
+	}
+	if format == P4InfoAuto {
+		format = formatFromContents(p4infoBytes)
+	}
+	err = UnmarshalP4Info(p4infoBytes, format, &p4info)
+	return
+}
+
+func UnmarshalP4Info(data []byte, format P4InfoFormat, p4info *p4_config_v1.P4Info) error {
+	if format == P4InfoAuto {
+		format = formatFromContents(data)
+	}
+	var err error
+	switch format {
+	case P4InfoText:
+		err = proto.UnmarshalText(string(data), p4info)
+	case P4InfoBinary:
+		err = proto.Unmarshal(data, p4info)
+	case P4InfoJSON:
+		err = jsonpb.Unmarshal(bytes.NewReader(data), p4info)
+	default:
+		err = fmt.Errorf("unknown P4Info format %v", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %v P4Info: %v", format, err)
+	}
+	return nil
+}
+
+func MarshalP4Info(p4info *p4_config_v1.P4Info, format P4InfoFormat) ([]byte, error) {
+	switch format {
+	case P4InfoAuto, P4InfoText:
+		return []byte(proto.MarshalTextString(p4info)), nil
+	case P4InfoBinary:
+		return proto.Marshal(p4info)
+	case P4InfoJSON:
+		m := jsonpb.Marshaler{Indent: "  "}
+		s, err := m.MarshalToString(p4info)
+		return []byte(s + "\n"), err
+	}
+	return nil, fmt.Errorf("unknown P4Info format %v", format)
+}
+
+// WriteP4Info writes a P4Info file; P4InfoAuto uses the file extension, defaulting to text
+func WriteP4Info(p4infoPath string, p4info *p4_config_v1.P4Info, format P4InfoFormat) error {
+	if format == P4InfoAuto {
+		format = formatFromPath(p4infoPath)
+	}
+	data, err := MarshalP4Info(p4info, format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p4infoPath, data, 0644)
+}
+
+func formatFromPath(path string) P4InfoFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".pbtxt", ".prototxt", ".textproto":
+		return P4InfoText
+	case ".bin", ".pb":
+		return P4InfoBinary
+	case ".json":
+		return P4InfoJSON
+	}
+	return P4InfoAuto
+}
+
+// formatFromContents treats data as JSON if it is an object, as text if it is printable, else as binary
+func formatFromContents(data []byte) P4InfoFormat {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return P4InfoJSON
+	}
+	if !utf8.Valid(data) {
+		return P4InfoBinary
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return P4InfoBinary
+		}
+	}
+	return P4InfoText
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+)
+
+// LintIssue is an inconsistency in a P4Info that the switch would reject or mishandle
+type LintIssue struct {
+	Object  string // e.g. "table", "action"
+	Name    string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Object, i.Name, i.Message)
+}
+
+// LintP4Info checks the consistency of a P4Info, e.g. before pushing it with SetForwardingPipelineConfig
+func LintP4Info(p4info *p4_config_v1.P4Info) []LintIssue {
+	l := &p4infoLint{
+		p4info: p4info,
+		ids:    map[uint32]string{},
+	}
+	kinds := []struct {
+		kind      string
+		prefix    p4_config_v1.P4Ids_Prefix
+		preambles []*p4_config_v1.Preamble
+	}{
+		{"table", p4_config_v1.P4Ids_TABLE, preambles(p4info.GetTables())},
+		{"action", p4_config_v1.P4Ids_ACTION, preambles(p4info.GetActions())},
+		{"action profile", p4_config_v1.P4Ids_ACTION_PROFILE, preambles(p4info.GetActionProfiles())},
+		{"counter", p4_config_v1.P4Ids_COUNTER, preambles(p4info.GetCounters())},
+		{"direct counter", p4_config_v1.P4Ids_DIRECT_COUNTER, preambles(p4info.GetDirectCounters())},
+		{"meter", p4_config_v1.P4Ids_METER, preambles(p4info.GetMeters())},
+		{"direct meter", p4_config_v1.P4Ids_DIRECT_METER, preambles(p4info.GetDirectMeters())},
+		{"register", p4_config_v1.P4Ids_REGISTER, preambles(p4info.GetRegisters())},
+		{"digest", p4_config_v1.P4Ids_DIGEST, preambles(p4info.GetDigests())},
+		{"controller packet metadata", p4_config_v1.P4Ids_CONTROLLER_HEADER, preambles(p4info.GetControllerPacketMetadata())},
+	}
+	for _, k := range kinds {
+		l.preambles(k.kind, k.prefix, k.preambles)
+	}
+
+	for _, t := range p4info.GetTables() {
+		l.table(t)
+	}
+	for _, a := range p4info.GetActions() {
+		var fields []p4infoField
+		for _, p := range a.GetParams() {
+			fields = append(fields, p4infoField{p.GetName(), p.GetId(), p.GetBitwidth(), 0})
+		}
+		l.fields("action", a.GetPreamble().GetName(), "param", fields)
+	}
+	for _, ap := range p4info.GetActionProfiles() {
+		for _, id := range ap.GetTableIds() {
+			if l.findTable(id) == nil {
+				l.issue("action profile", ap.GetPreamble().GetName(), "refers to unknown table id %d", id)
+			}
+		}
+	}
+	for _, c := range p4info.GetDirectCounters() {
+		l.directResource("direct counter", c.GetPreamble(), c.GetDirectTableId())
+	}
+	for _, m := range p4info.GetDirectMeters() {
+		l.directResource("direct meter", m.GetPreamble(), m.GetDirectTableId())
+	}
+	for _, m := range p4info.GetControllerPacketMetadata() {
+		var fields []p4infoField
+		var bits int32
+		for _, f := range m.GetMetadata() {
+			fields = append(fields, p4infoField{f.GetName(), f.GetId(), f.GetBitwidth(), 0})
+			bits += f.GetBitwidth()
+		}
+		l.fields("controller packet metadata", m.GetPreamble().GetName(), "metadata", fields)
+		if bits%8 != 0 {
+			l.issue("controller packet metadata", m.GetPreamble().GetName(),
+				"total bitwidth %d is not a multiple of 8", bits)
+		}
+	}
+	return l.issues
+}
+
+type p4infoLint struct {
+	p4info *p4_config_v1.P4Info
+	ids    map[uint32]string // id -> kind and name of the first object with the id
+	issues []LintIssue
+}
+
+func (l *p4infoLint) issue(object, name, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{Object: object, Name: name, Message: fmt.Sprintf(format, args...)})
+}
+
+// preambles checks the ids (unique across all objects, with the prefix of the kind) and the names of one kind of object
+func (l *p4infoLint) preambles(kind string, prefix p4_config_v1.P4Ids_Prefix, preambles []*p4_config_v1.Preamble) {
+	names := map[string]bool{}
+	aliases := map[string]bool{}
+	for _, p := range preambles {
+		if other, ok := l.ids[p.GetId()]; ok {
+			l.issue(kind, p.GetName(), "id 0x%08x is also used by %s", p.GetId(), other)
+		} else {
+			l.ids[p.GetId()] = kind + " " + p.GetName()
+		}
+		if p4_config_v1.P4Ids_Prefix(p.GetId()>>24) != prefix {
+			l.issue(kind, p.GetName(), "id 0x%08x does not have the %v prefix 0x%02x", p.GetId(), prefix, int32(prefix))
+		}
+		if names[p.GetName()] {
+			l.issue(kind, p.GetName(), "duplicate name")
+		}
+		names[p.GetName()] = true
+		if p.GetAlias() != "" {
+			if aliases[p.GetAlias()] {
+				l.issue(kind, p.GetName(), "duplicate alias %s", p.GetAlias())
+			}
+			aliases[p.GetAlias()] = true
+		}
+	}
+}
+
+// fields checks that the match fields, params or metadata of an object have unique ids and names
+func (l *p4infoLint) fields(object, name, kind string, fields []p4infoField) {
+	ids := map[uint32]bool{}
+	names := map[string]bool{}
+	for _, f := range fields {
+		if ids[f.id] {
+			l.issue(object, name, "duplicate %s id %d (%s)", kind, f.id, f.name)
+		}
+		if names[f.name] {
+			l.issue(object, name, "duplicate %s name %s", kind, f.name)
+		}
+		ids[f.id] = true
+		names[f.name] = true
+	}
+}
+
+func (l *p4infoLint) table(t *p4_config_v1.Table) {
+	name := t.GetPreamble().GetName()
+	var fields []p4infoField
+	for _, mf := range t.GetMatchFields() {
+		fields = append(fields, p4infoField{mf.GetName(), mf.GetId(), mf.GetBitwidth(), int64(mf.GetMatchType())})
+	}
+	l.fields("table", name, "match field", fields)
+
+	refs := map[uint32]bool{}
+	for _, ref := range t.GetActionRefs() {
+		refs[ref.GetId()] = true
+		if l.findAction(ref.GetId()) == nil {
+			l.issue("table", name, "refers to unknown action id %d", ref.GetId())
+		}
+	}
+	if id := t.GetConstDefaultActionId(); id != 0 && !refs[id] {
+		l.issue("table", name, "const default action id %d is not one of the table's actions", id)
+	}
+	if id := t.GetImplementationId(); id != 0 {
+		found := false
+		for _, ap := range l.p4info.GetActionProfiles() {
+			found = found || ap.GetPreamble().GetId() == id
+		}
+		if !found {
+			l.issue("table", name, "implementation id %d is not an action profile", id)
+		}
+	}
+	for _, id := range t.GetDirectResourceIds() {
+		var tableId uint32
+		found := false
+		for _, c := range l.p4info.GetDirectCounters() {
+			if c.GetPreamble().GetId() == id {
+				tableId, found = c.GetDirectTableId(), true
+			}
+		}
+		for _, m := range l.p4info.GetDirectMeters() {
+			if m.GetPreamble().GetId() == id {
+				tableId, found = m.GetDirectTableId(), true
+			}
+		}
+		if !found {
+			l.issue("table", name, "direct resource id %d is not a direct counter or meter", id)
+		} else if tableId != t.GetPreamble().GetId() {
+			l.issue("table", name, "direct resource id %d belongs to table id %d", id, tableId)
+		}
+	}
+}
+
+func (l *p4infoLint) directResource(kind string, preamble *p4_config_v1.Preamble, tableId uint32) {
+	table := l.findTable(tableId)
+	if table == nil {
+		l.issue(kind, preamble.GetName(), "refers to unknown table id %d", tableId)
+		return
+	}
+	for _, id := range table.GetDirectResourceIds() {
+		if id == preamble.GetId() {
+			return
+		}
+	}
+	l.issue(kind, preamble.GetName(), "is not a direct resource of table %s", table.GetPreamble().GetName())
+}
+
+func (l *p4infoLint) findTable(id uint32) *p4_config_v1.Table {
+	for _, t := range l.p4info.GetTables() {
+		if t.GetPreamble().GetId() == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (l *p4infoLint) findAction(id uint32) *p4_config_v1.Action {
+	for _, a := range l.p4info.GetActions() {
+		if a.GetPreamble().GetId() == id {
+			return a
+		}
+	}
+	return nil
+}
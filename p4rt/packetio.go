@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"math/big"
+	"strings"
+)
+
+// PacketIn is a packet received from the switch with its controller_packet_metadata decoded by name
+type PacketIn struct {
+	Payload     []byte
+	Metadata    map[string]*big.Int
+	RawMetadata []*p4.PacketMetadata
+}
+
+func (c *p4rtClient) findPacketMetadata(name string) (*p4_config_v1.ControllerPacketMetadata, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.ControllerPacketMetadata(name)
+}
+
+// SendPacketOut sends a packet to the switch; metadata values are integers or []byte (see EncodeP4Data)
+// keyed by the names in the packet_out controller_packet_metadata. Missing padding fields (named "_...", e.g. "_pad")
+// are zero; any other missing field is an error.
+func (c *p4rtClient) SendPacketOut(payload []byte, metadata map[string]interface{}) error {
+	info, err := c.findPacketMetadata("packet_out")
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]bool)
+	for _, m := range info.GetMetadata() {
+		fields[m.GetName()] = true
+	}
+	for name := range metadata {
+		if !fields[name] {
+			return fmt.Errorf("unknown packet_out metadata %s", name)
+		}
+	}
+
+	packet := &p4.PacketOut{Payload: payload}
+	for _, m := range info.GetMetadata() {
+		value, ok := metadata[m.GetName()]
+		if !ok {
+			if !strings.HasPrefix(m.GetName(), "_") {
+				return fmt.Errorf("missing packet_out metadata %s", m.GetName())
+			}
+			value = 0
+		}
+		b, err := encodeBitstring(value, m.GetBitwidth(), false)
+		if err != nil {
+			return fmt.Errorf("packet_out metadata %s: %v", m.GetName(), err)
+		}
+		packet.Metadata = append(packet.Metadata, &p4.PacketMetadata{
+			MetadataId: m.GetId(),
+			Value:      b,
+		})
+	}
+	return c.sendStream(&p4.StreamMessageRequest{
+		Update: &p4.StreamMessageRequest_Packet{Packet: packet},
+	})
+}
+
+// SetPacketInChan sets the channel on which received packets are delivered; nil discards them
+func (c *p4rtClient) SetPacketInChan(packetInChan chan PacketIn) {
+	c.packetInChan = packetInChan
+}
+
+func (c *p4rtClient) handlePacketIn(packet *p4.PacketIn) {
+	if c.packetInChan == nil {
+		return
+	}
+	packetIn := PacketIn{
+		Payload:     packet.GetPayload(),
+		Metadata:    make(map[string]*big.Int),
+		RawMetadata: packet.GetMetadata(),
+	}
+	if info, err := c.findPacketMetadata("packet_in"); err == nil {
+		names := make(map[uint32]string)
+		for _, m := range info.GetMetadata() {
+			names[m.GetId()] = m.GetName()
+		}
+		for _, m := range packet.GetMetadata() {
+			if name, ok := names[m.GetMetadataId()]; ok {
+				packetIn.Metadata[name] = new(big.Int).SetBytes(m.GetValue())
+			}
+		}
+	}
+	select {
+	case c.packetInChan <- packetIn: // put packet into the channel unless it is full
+	default:
+		fmt.Println("Packet-in channel full. Discarding packet")
+	}
+}
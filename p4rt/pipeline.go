@@ -22,66 +22,62 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
-	"github.com/golang/protobuf/proto"
 	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
 	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
 	"github.com/pkg/errors"
-	"io/ioutil"
 )
 
 type P4DeviceConfig []byte
 
-func LoadP4Info(p4infoPath string) (p4info p4_config_v1.P4Info, err error) {
-	fmt.Printf("P4 Info: %s\n", p4infoPath)
-
-	p4infoBytes, err := ioutil.ReadFile(p4infoPath)
-	if err != nil {
-		return
-	}
-	err = proto.UnmarshalText(string(p4infoBytes), &p4info)
-	return
+// LoadP4Info loads a P4Info file in text, binary or JSON format (see P4InfoAuto)
+func LoadP4Info(p4infoPath string) (p4_config_v1.P4Info, error) {
+	return LoadP4InfoFormat(p4infoPath, P4InfoAuto)
 }
 
 func BuildPipelineConfig(p4info p4_config_v1.P4Info, deviceConfigPath string) (config p4.ForwardingPipelineConfig, err error) {
-	deviceConfig, err := LoadDeviceConfig(deviceConfigPath)
+	return BuildTargetPipelineConfig(p4info, "", deviceConfigPath)
+}
+
+// BuildTargetPipelineConfig builds the pipeline with the device config builder registered for target
+func BuildTargetPipelineConfig(p4info p4_config_v1.P4Info, target, deviceConfigPath string) (config p4.ForwardingPipelineConfig, err error) {
+	deviceConfig, err := LoadTargetDeviceConfig(target, deviceConfigPath)
 	if err != nil {
 		return
 	}
+	return newPipelineConfig(&p4info, deviceConfig), nil
+}
 
+func newPipelineConfig(p4info *p4_config_v1.P4Info, deviceConfig P4DeviceConfig) (config p4.ForwardingPipelineConfig) {
 	// Compute the cookie as the hash of the device config
 	hash := md5.Sum(deviceConfig)
 	cookie := binary.LittleEndian.Uint64(hash[:])
 
-	config.P4Info = &p4info
+	config.P4Info = p4info
 	config.P4DeviceConfig = deviceConfig
 	config.Cookie = &p4.ForwardingPipelineConfig_Cookie{Cookie: cookie}
 	return
 }
 
-func getPipelineConfig(client p4.P4RuntimeClient, deviceId uint64) (*p4.ForwardingPipelineConfig, error) {
+func getPipelineConfig(client p4.P4RuntimeClient, deviceId uint64,
+	responseType p4.GetForwardingPipelineConfigRequest_ResponseType) (*p4.ForwardingPipelineConfig, error) {
 	req := &p4.GetForwardingPipelineConfigRequest{
 		DeviceId:     deviceId,
-		ResponseType: p4.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE,
+		ResponseType: responseType,
 	}
 	res, err := client.GetForwardingPipelineConfig(context.Background(), req)
-
-	//TODO update ErrorDesc to use non-deprecated method
-	//if grpc.ErrorDesc(err) == "No forwarding pipeline config set for this device" {
-	//	fmt.Println("no forwarding pipeline; need to push one")
-	//	return &p4.ForwardingPipelineConfig{}, nil
-	//} else
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting pipeline config")
 	}
 	return res.GetConfig(), nil
 }
 
-func setPipelineConfig(client p4.P4RuntimeClient, deviceId uint64, electionId *p4.Uint128, config *p4.ForwardingPipelineConfig) error {
+func setPipelineConfig(client p4.P4RuntimeClient, deviceId uint64, electionId *p4.Uint128,
+	action p4.SetForwardingPipelineConfigRequest_Action, config *p4.ForwardingPipelineConfig) error {
 	req := &p4.SetForwardingPipelineConfigRequest{
 		DeviceId: deviceId,
 		RoleId:   0, // not used
 		ElectionId: electionId,
-		Action: p4.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT,
+		Action: action,
 		Config: config,
 	}
 	_, err := client.SetForwardingPipelineConfig(context.Background(), req)
@@ -89,60 +85,199 @@ func setPipelineConfig(client p4.P4RuntimeClient, deviceId uint64, electionId *p
 	return err
 }
 
-func (c *p4rtClient) SetForwardingPipelineConfig(p4InfoPath, deviceConfigPath string) (err error) {
-	p4info, err := LoadP4Info(p4InfoPath)
-	if err != nil {
-		return
+// PipelineOption modifies how the pipeline is built and pushed
+type PipelineOption func(*pipelineOptions)
+
+type pipelineOptions struct {
+	target       string
+	action       p4.SetForwardingPipelineConfigRequest_Action
+	force        bool
+	responseType p4.GetForwardingPipelineConfigRequest_ResponseType
+}
+
+// WithTarget selects the device config builder, overriding the client's target (see SetTarget)
+func WithTarget(target string) PipelineOption {
+	return func(o *pipelineOptions) {
+		o.target = target
 	}
-	pipeline, err := BuildPipelineConfig(p4info, deviceConfigPath)
-	if err != nil {
-		return
+}
+
+// WithAction sets the SetForwardingPipelineConfig action; the default is VERIFY_AND_COMMIT.
+// Use CommitForwardingPipelineConfig to commit a config pushed with VERIFY_AND_SAVE.
+func WithAction(action p4.SetForwardingPipelineConfigRequest_Action) PipelineOption {
+	return func(o *pipelineOptions) {
+		o.action = action
 	}
-	err = setPipelineConfig(c.client, c.deviceId, &c.electionId, &pipeline)
-	if err != nil {
-		return
+}
+
+// WithResponseType selects what GetForwardingPipelineConfig returns; the default is P4INFO_AND_COOKIE
+func WithResponseType(responseType p4.GetForwardingPipelineConfigRequest_ResponseType) PipelineOption {
+	return func(o *pipelineOptions) {
+		o.responseType = responseType
 	}
-	return
 }
 
-func (c *p4rtClient) GetForwardingPipelineConfig() (*p4.ForwardingPipelineConfig, error) {
-	return getPipelineConfig(c.client, c.deviceId)
+// SetTarget sets the device config builder used by SetForwardingPipelineConfig; "" detects it from the paths
+func (c *p4rtClient) SetTarget(target string) {
+	c.target = target
+}
+
+func (c *p4rtClient) pipelineOptions(opts []PipelineOption) *pipelineOptions {
+	o := &pipelineOptions{
+		target:       c.target,
+		action:       p4.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT,
+		responseType: p4.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// PipelineResult is the outcome of a successful SetForwardingPipelineConfig
+type PipelineResult struct {
+	Action p4.SetForwardingPipelineConfigRequest_Action
+	Cookie uint64
+	// Committed is false for VERIFY and VERIFY_AND_SAVE, after which the switch still runs its previous pipeline
+	Committed bool
+}
+
+func (r PipelineResult) String() string {
+	state := "not committed"
+	if r.Committed {
+		state = "committed"
+	}
+	return fmt.Sprintf("%v cookie 0x%016x (%s)", r.Action, r.Cookie, state)
 }
 
-/* FIXME(bocon)
+// SetForwardingPipelineConfig pushes the pipeline built from p4InfoPath and deviceConfigPath.
+// p4InfoPath may instead be a pipeline bundle (see LoadPipelineBundle), with an empty deviceConfigPath.
+func (c *p4rtClient) SetForwardingPipelineConfig(p4InfoPath, deviceConfigPath string, opts ...PipelineOption) error {
+	_, err := c.PushForwardingPipelineConfig(p4InfoPath, deviceConfigPath, opts...)
+	return err
+}
 
-func matches(target, actual *p4.ForwardingPipelineConfig) bool {
-	// TODO Tofino doesn't appear to fill in the device config on Get, so assume it matches
-	// When it does, we can replace this with proto compare: proto.Equal(target, actual)
-	// TODO consider using cookie for comparision
-	return proto.Equal(target.P4Info, actual.P4Info)
+// PushForwardingPipelineConfig builds the pipeline and pushes it with the action set by WithAction
+func (c *p4rtClient) PushForwardingPipelineConfig(p4InfoPath, deviceConfigPath string,
+	opts ...PipelineOption) (*PipelineResult, error) {
+	options := c.pipelineOptions(opts)
+	pipeline, err := loadPipeline(p4InfoPath, deviceConfigPath, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.pushPipelineConfig(options.action, pipeline)
 }
 
-func UpdatePipelineConfig(client p4.P4RuntimeClient, p4Info *p4_config_v1.P4Info,
-	config PipelineConfig, deviceId uint64, forcePush bool) (bool, error) {
-	configData, err := config.Get()
+// loadPipeline builds the pipeline from a P4Info and a device config, or from a bundle given as p4InfoPath
+func loadPipeline(p4InfoPath, deviceConfigPath string, options *pipelineOptions) (*p4.ForwardingPipelineConfig, error) {
+	if deviceConfigPath == "" && IsPipelineBundle(p4InfoPath) {
+		bundle, err := LoadPipelineBundle(p4InfoPath)
+		if err != nil {
+			return nil, err
+		}
+		return bundle.PipelineConfig(options.target)
+	}
+	p4info, err := LoadP4Info(p4InfoPath)
 	if err != nil {
-		return false, errors.Wrap(err, "error building target config")
+		return nil, err
 	}
-	targetConfig := &p4.ForwardingPipelineConfig{
-		P4Info:         p4Info,
-		P4DeviceConfig: configData,
+	pipeline, err := BuildTargetPipelineConfig(p4info, options.target, deviceConfigPath)
+	if err != nil {
+		return nil, err
 	}
+	return &pipeline, nil
+}
+
+// CommitForwardingPipelineConfig commits the config saved by a VERIFY_AND_SAVE push, which may have been
+// done by another client; the P4Info of the committed pipeline is then fetched from the switch
+func (c *p4rtClient) CommitForwardingPipelineConfig() (*PipelineResult, error) {
+	return c.pushPipelineConfig(p4.SetForwardingPipelineConfigRequest_COMMIT, nil)
+}
 
-	deviceConfig, err := GetPipelineConfigs(client, deviceId)
+func (c *p4rtClient) pushPipelineConfig(action p4.SetForwardingPipelineConfigRequest_Action,
+	pipeline *p4.ForwardingPipelineConfig) (*PipelineResult, error) {
+	switch action {
+	case p4.SetForwardingPipelineConfigRequest_UNSPECIFIED:
+		return nil, fmt.Errorf("no SetForwardingPipelineConfig action")
+	case p4.SetForwardingPipelineConfigRequest_COMMIT:
+		if pipeline != nil {
+			return nil, fmt.Errorf("COMMIT takes no config; use CommitForwardingPipelineConfig")
+		}
+	}
+	err := setPipelineConfig(c.client, c.deviceId, &c.electionId, action, pipeline)
 	if err != nil {
-		return false, errors.Wrap(err, "error getting device config")
+		return nil, err
 	}
 
-	if forcePush || !matches(targetConfig, deviceConfig) {
-		// Config doesn't match or updated is forced, so re-push...
-		err = setPipelineConfig(client, targetConfig)
-		if err != nil {
-			return true, errors.Wrap(err, "error setting config")
+	result := &PipelineResult{Action: action}
+	switch action {
+	case p4.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE:
+		c.savedP4info, c.savedCookie = pipeline.GetP4Info(), pipeline.GetCookie().GetCookie()
+		result.Cookie = c.savedCookie
+	case p4.SetForwardingPipelineConfigRequest_COMMIT:
+		result.Committed = true
+		p4info, cookie := c.savedP4info, c.savedCookie
+		c.savedP4info, c.savedCookie = nil, 0
+		if p4info == nil {
+			// saved by another client (or an earlier run), so get the committed pipeline from the switch
+			config, err := getPipelineConfig(c.client, c.deviceId, p4.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE)
+			if err != nil {
+				return result, errors.Wrap(err, "committed, but could not get the P4Info")
+			}
+			p4info, cookie = config.GetP4Info(), config.GetCookie().GetCookie()
 		}
-		return true, nil
+		c.p4info = NewP4InfoIndex(p4info)
+		result.Cookie = cookie
+	case p4.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT, p4.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT:
+		c.p4info = NewP4InfoIndex(pipeline.GetP4Info())
+		result.Cookie, result.Committed = pipeline.GetCookie().GetCookie(), true
+	default:
+		result.Cookie = pipeline.GetCookie().GetCookie()
 	}
-	return false, nil
+	return result, nil
 }
 
-*/
+// SetP4Info sets the P4Info used for name lookups without pushing a pipeline
+// (e.g. when the pipeline was pushed by another controller)
+func (c *p4rtClient) SetP4Info(p4info *p4_config_v1.P4Info) {
+	c.p4info = NewP4InfoIndex(p4info)
+}
+
+// P4Info returns the index of the client's P4Info, or nil if none is loaded
+func (c *p4rtClient) P4Info() *P4InfoIndex {
+	return c.p4info
+}
+
+func (c *p4rtClient) index() (*P4InfoIndex, error) {
+	if c.p4info == nil {
+		return nil, fmt.Errorf("no P4Info loaded")
+	}
+	return c.p4info, nil
+}
+
+func (c *p4rtClient) GetForwardingPipelineConfig(opts ...PipelineOption) (*p4.ForwardingPipelineConfig, error) {
+	return getPipelineConfig(c.client, c.deviceId, c.pipelineOptions(opts).responseType)
+}
+
+// SavePipelineConfig writes the P4Info and device config of a pipeline (e.g. returned by
+// GetForwardingPipelineConfig with ALL) to files that LoadP4Info and LoadDeviceConfig read back.
+// Either path may be empty to skip that part.
+func SavePipelineConfig(config *p4.ForwardingPipelineConfig, p4infoPath, deviceConfigPath string) error {
+	if p4infoPath != "" {
+		if config.GetP4Info() == nil {
+			return fmt.Errorf("pipeline config has no P4Info")
+		}
+		if err := WriteP4Info(p4infoPath, config.GetP4Info(), P4InfoAuto); err != nil {
+			return err
+		}
+	}
+	if deviceConfigPath != "" {
+		if len(config.GetP4DeviceConfig()) == 0 {
+			return fmt.Errorf("pipeline config has no device config")
+		}
+		if err := SaveDeviceConfig(deviceConfigPath, config.GetP4DeviceConfig()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,5 +1,3 @@
-// +build bmv2
-
 /*
  * Copyright 2020-present Brian O'Connor
  *
@@ -17,8 +15,6 @@
  *
  */
 
-// To include this file, use go build -tags tofino
-
 package p4rt
 
 import (
@@ -27,7 +23,8 @@ import (
 	"os"
 )
 
-func LoadDeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
+// loadBmv2DeviceConfig loads the BMv2 JSON generated by p4c
+func loadBmv2DeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
 	fmt.Printf("BMv2 JSON: %s\n", deviceConfigPath)
 
 	deviceConfig, err := os.Open(deviceConfigPath)
@@ -1,5 +1,3 @@
-// +build tofino
-
 /*
  * Copyright 2020-present Brian O'Connor
  *
@@ -17,19 +15,54 @@
  *
  */
 
-// To include this file, use go build -tags tofino
-
 package p4rt
 
 import (
+	"bytes"
+	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"strings"
+	"unicode/utf8"
+)
+
+// TOFINO_PIPECONF_NAME is the pipeconf name of Tofino device configs built without one
+var TOFINO_PIPECONF_NAME = "p4rt-go-gen"
+
+type TofinoConfigFormat int
+
+const (
+	// TofinoPacked is the legacy format: the pipeconf name, tofino.bin and context.json, each length-prefixed
+	TofinoPacked TofinoConfigFormat = iota
+	// TofinoBfPipelineConfig is a serialized BfPipelineConfig, expected by newer Stratum builds
+	TofinoBfPipelineConfig
 )
 
-func LoadDeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
+// TofinoDeviceConfigBuilder returns a builder for Tofino device configs in the given format, with the
+// pipeconf name (or TOFINO_PIPECONF_NAME if empty), e.g. to register for a target:
+//
+//	RegisterDeviceConfigBuilder("tofino", TofinoDeviceConfigBuilder("my-pipeconf", TofinoBfPipelineConfig))
+//
+// The packed format takes "tofino.bin,context.json"; BfPipelineConfig takes the same (optionally
+// followed by bf-rt.json) for a single profile, or the .conf file written by p4c for several.
+func TofinoDeviceConfigBuilder(pipeconfName string, format TofinoConfigFormat) DeviceConfigBuilder {
+	return func(deviceConfigPath string) (P4DeviceConfig, error) {
+		if format == TofinoBfPipelineConfig {
+			return buildBfPipelineConfig(pipeconfName, deviceConfigPath)
+		}
+		name := pipeconfName
+		if name == "" {
+			name = TOFINO_PIPECONF_NAME
+		}
+		return buildTofinoDeviceConfig(name, deviceConfigPath)
+	}
+}
+
+// buildTofinoDeviceConfig packs the pipeconf name, tofino.bin and context.json, given as
+// "tofino.bin,context.json", in the legacy format expected by Stratum
+func buildTofinoDeviceConfig(pipeconfName, deviceConfigPath string) (P4DeviceConfig, error) {
 	paths := strings.Split(deviceConfigPath, ",")
 	if len(paths) != 2 {
 		return nil, errors.New("Device Config Path is invalid.\n\n" +
@@ -51,61 +84,94 @@ func LoadDeviceConfig(deviceConfigPath string) (P4DeviceConfig, error) {
 			"For Tofino targets, the context.json comes second, and must end in \".json\"")
 	}
 
-	pipeconfName := "p4rt-go-gen"
-
-	tofinoBin, err := os.Open(tofinoBinPath)
+	tofinoBin, err := ioutil.ReadFile(tofinoBinPath)
 	if err != nil {
-		return nil, fmt.Errorf("open %s: %v", tofinoBinPath, err)
+		return nil, err
 	}
-	defer tofinoBin.Close()
-	tofinoBinInfo, err := tofinoBin.Stat()
+	tofinoContext, err := ioutil.ReadFile(tofinoContextPath)
 	if err != nil {
-		return nil, fmt.Errorf("stat %s: %v", tofinoBinPath, err)
+		return nil, err
 	}
 
-	tofinoContext, err := os.Open(tofinoContextPath)
-	if err != nil {
-		return nil, fmt.Errorf("open %s: %v", tofinoContextPath, err)
-	}
-	defer tofinoContext.Close()
-	tofinoContextInfo, err := tofinoContext.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("stat %s: %v", tofinoContextPath, err)
+	config := &TofinoDeviceConfig{
+		PipeconfName: pipeconfName,
+		Bin:          tofinoBin,
+		Context:      tofinoContext,
 	}
+	return config.Pack(), nil
+}
+
+// TofinoDeviceConfig is the pipeconf name, tofino.bin and context.json packed into a Tofino device config
+type TofinoDeviceConfig struct {
+	PipeconfName string
+	Bin          []byte
+	Context      []byte
+}
 
-	// Allocate the device config buffer
-	binLen := len(pipeconfName) + int(tofinoBinInfo.Size()) + int(tofinoContextInfo.Size()) + 12 // 3 * 32bit int
-	bin := make([]byte, binLen)
+// Pack packs the config as three length-prefixed fields (32-bit little endian lengths): name, bin and context
+func (t *TofinoDeviceConfig) Pack() P4DeviceConfig {
+	bin := make([]byte, 0, len(t.PipeconfName)+len(t.Bin)+len(t.Context)+12) // 3 * 32bit int
+	for _, field := range [][]byte{[]byte(t.PipeconfName), t.Bin, t.Context} {
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(field)))
+		bin = append(bin, length[:]...)
+		bin = append(bin, field...)
+	}
+	return bin
+}
 
-	i := 0
-	binary.LittleEndian.PutUint32(bin[i:], uint32(len(pipeconfName)))
-	i += 4
-	if b := copy(bin[i:], pipeconfName); b != len(pipeconfName) {
-		panic("pipeconf name copy failed")
+// ParseTofinoDeviceConfig splits a device config built by Pack (or fetched from the switch with
+// GetForwardingPipelineConfig) back into its parts
+func ParseTofinoDeviceConfig(deviceConfig P4DeviceConfig) (*TofinoDeviceConfig, error) {
+	var fields [3][]byte
+	rest := []byte(deviceConfig)
+	for i, name := range []string{"pipeconf name", "tofino bin", "tofino context"} {
+		if len(rest) < 4 {
+			return nil, fmt.Errorf("tofino device config is truncated: missing the %s length", name)
+		}
+		length := binary.LittleEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(length) > uint64(len(rest)) {
+			return nil, fmt.Errorf("tofino device config is truncated: %s length is %d, but only %d bytes remain",
+				name, length, len(rest))
+		}
+		fields[i], rest = rest[:length], rest[length:]
 	}
-	i += len(pipeconfName)
-
-	binary.LittleEndian.PutUint32(bin[i:], uint32(tofinoBinInfo.Size()))
-	i += 4
-	if b, err := tofinoBin.Read(bin[i:]); err != nil {
-		return nil, fmt.Errorf("read %s: %v", tofinoBinPath, err)
-	} else if b != int(tofinoBinInfo.Size()) {
-		return nil, errors.New("tofino bin copy failed")
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("tofino device config has %d trailing bytes", len(rest))
 	}
-	i += int(tofinoBinInfo.Size())
-
-	binary.LittleEndian.PutUint32(bin[i:], uint32(tofinoContextInfo.Size()))
-	i += 4
-	if b, err := tofinoContext.Read(bin[i:]); err != nil {
-		return nil, fmt.Errorf("read %s: %v", tofinoContextPath, err)
-	} else if b != int(tofinoContextInfo.Size()) {
-		return nil, errors.New("tofino context copy failed")
+	if !utf8.Valid(fields[0]) {
+		return nil, fmt.Errorf("tofino pipeconf name is not valid UTF-8")
 	}
-	i += int(tofinoContextInfo.Size())
+	return &TofinoDeviceConfig{
+		PipeconfName: string(fields[0]),
+		Bin:          fields[1],
+		Context:      fields[2],
+	}, nil
+}
 
-	if i != binLen {
-		return nil, errors.New("failed to build device config for tofino")
+// Diff describes how t differs from other, e.g. the config loaded on a switch from the one built locally;
+// it is empty if they are the same
+func (t *TofinoDeviceConfig) Diff(other *TofinoDeviceConfig) []string {
+	var diffs []string
+	if t.PipeconfName != other.PipeconfName {
+		diffs = append(diffs, fmt.Sprintf("pipeconf name %q != %q", t.PipeconfName, other.PipeconfName))
+	}
+	if !bytes.Equal(t.Bin, other.Bin) {
+		diffs = append(diffs, fmt.Sprintf("tofino bin %d bytes (md5 %x) != %d bytes (md5 %x)",
+			len(t.Bin), md5.Sum(t.Bin), len(other.Bin), md5.Sum(other.Bin)))
+	}
+	if !bytes.Equal(t.Context, other.Context) {
+		diffs = append(diffs, fmt.Sprintf("tofino context %d bytes (md5 %x) != %d bytes (md5 %x)",
+			len(t.Context), md5.Sum(t.Context), len(other.Context), md5.Sum(other.Context)))
 	}
+	return diffs
+}
 
-	return bin, nil
+// Write writes the bin and context to files that LoadDeviceConfig reads back as "tofino.bin,context.json"
+func (t *TofinoDeviceConfig) Write(tofinoBinPath, tofinoContextPath string) error {
+	if err := ioutil.WriteFile(tofinoBinPath, t.Bin, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tofinoContextPath, t.Context, 0644)
 }
@@ -0,0 +1,416 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// BfPipelineConfig is Stratum's Tofino device config (stratum/hal/lib/barefoot/bf.proto):
+//
+//	message BfPipelineConfig {
+//	  message Profile {
+//	    string profile_name = 1;
+//	    bytes context = 2;
+//	    bytes binary = 3;
+//	    repeated uint32 pipe_scope = 4;
+//	  }
+//	  string p4_name = 1;
+//	  bytes bfruntime_info = 2;
+//	  repeated Profile profiles = 3;
+//	}
+//
+// It is encoded by hand so that p4rt-go doesn't depend on the Stratum protos.
+type BfPipelineConfig struct {
+	P4Name        string
+	BfruntimeInfo []byte
+	Profiles      []BfPipelineProfile
+}
+
+// BfPipelineProfile is the compiler output for the pipes in PipeScope (all pipes if empty)
+type BfPipelineProfile struct {
+	ProfileName string
+	Context     []byte
+	Binary      []byte
+	PipeScope   []uint32
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func encodeTag(b *proto.Buffer, field, wireType uint64) {
+	b.EncodeVarint(field<<3 | wireType)
+}
+
+// Marshal encodes the config in the protobuf wire format
+func (c *BfPipelineConfig) Marshal() P4DeviceConfig {
+	b := proto.NewBuffer(nil)
+	if c.P4Name != "" {
+		encodeTag(b, 1, wireBytes)
+		b.EncodeStringBytes(c.P4Name)
+	}
+	if len(c.BfruntimeInfo) > 0 {
+		encodeTag(b, 2, wireBytes)
+		b.EncodeRawBytes(c.BfruntimeInfo)
+	}
+	for _, profile := range c.Profiles {
+		encodeTag(b, 3, wireBytes)
+		b.EncodeRawBytes(profile.marshal())
+	}
+	return b.Bytes()
+}
+
+func (p *BfPipelineProfile) marshal() []byte {
+	b := proto.NewBuffer(nil)
+	if p.ProfileName != "" {
+		encodeTag(b, 1, wireBytes)
+		b.EncodeStringBytes(p.ProfileName)
+	}
+	if len(p.Context) > 0 {
+		encodeTag(b, 2, wireBytes)
+		b.EncodeRawBytes(p.Context)
+	}
+	if len(p.Binary) > 0 {
+		encodeTag(b, 3, wireBytes)
+		b.EncodeRawBytes(p.Binary)
+	}
+	if len(p.PipeScope) > 0 {
+		// proto3 packs repeated scalars
+		scope := proto.NewBuffer(nil)
+		for _, pipe := range p.PipeScope {
+			scope.EncodeVarint(uint64(pipe))
+		}
+		encodeTag(b, 4, wireBytes)
+		b.EncodeRawBytes(scope.Bytes())
+	}
+	return b.Bytes()
+}
+
+// ParseBfPipelineConfig decodes a BfPipelineConfig, e.g. fetched from the switch with GetForwardingPipelineConfig
+func ParseBfPipelineConfig(deviceConfig P4DeviceConfig) (*BfPipelineConfig, error) {
+	c := &BfPipelineConfig{}
+	err := decodeFields(deviceConfig, func(b *proto.Buffer, field, wireType uint64) (bool, error) {
+		var err error
+		switch {
+		case field == 1 && wireType == wireBytes:
+			c.P4Name, err = b.DecodeStringBytes()
+		case field == 2 && wireType == wireBytes:
+			c.BfruntimeInfo, err = b.DecodeRawBytes(true)
+		case field == 3 && wireType == wireBytes:
+			var data []byte
+			if data, err = b.DecodeRawBytes(false); err == nil {
+				var profile *BfPipelineProfile
+				if profile, err = parseBfPipelineProfile(data); err == nil {
+					c.Profiles = append(c.Profiles, *profile)
+				}
+			}
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid BfPipelineConfig: %v", err)
+	}
+	if c.P4Name == "" || len(c.Profiles) == 0 {
+		return nil, fmt.Errorf("invalid BfPipelineConfig: no p4_name or profiles")
+	}
+	return c, nil
+}
+
+func parseBfPipelineProfile(data []byte) (*BfPipelineProfile, error) {
+	p := &BfPipelineProfile{}
+	err := decodeFields(data, func(b *proto.Buffer, field, wireType uint64) (bool, error) {
+		var err error
+		switch {
+		case field == 1 && wireType == wireBytes:
+			p.ProfileName, err = b.DecodeStringBytes()
+		case field == 2 && wireType == wireBytes:
+			p.Context, err = b.DecodeRawBytes(true)
+		case field == 3 && wireType == wireBytes:
+			p.Binary, err = b.DecodeRawBytes(true)
+		case field == 4 && wireType == wireBytes:
+			var packed []byte
+			if packed, err = b.DecodeRawBytes(false); err == nil {
+				scope := proto.NewBuffer(packed)
+				for err == nil && len(scope.Unread()) > 0 {
+					var pipe uint64
+					pipe, err = scope.DecodeVarint()
+					p.PipeScope = append(p.PipeScope, uint32(pipe))
+				}
+			}
+		case field == 4 && wireType == wireVarint:
+			var pipe uint64
+			pipe, err = b.DecodeVarint()
+			p.PipeScope = append(p.PipeScope, uint32(pipe))
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("profile: %v", err)
+	}
+	return p, nil
+}
+
+// decodeFields calls decode for each field of a message, and skips the fields it doesn't decode
+func decodeFields(data []byte, decode func(b *proto.Buffer, field, wireType uint64) (bool, error)) error {
+	b := proto.NewBuffer(data)
+	for len(b.Unread()) > 0 {
+		tag, err := b.DecodeVarint()
+		if err != nil {
+			return err
+		}
+		field, wireType := tag>>3, tag&7
+		if field == 0 {
+			return fmt.Errorf("invalid field number 0")
+		}
+		if ok, err := decode(b, field, wireType); err != nil {
+			return fmt.Errorf("field %d: %v", field, err)
+		} else if ok {
+			continue
+		}
+		switch wireType {
+		case wireVarint:
+			_, err = b.DecodeVarint()
+		case wireFixed64:
+			_, err = b.DecodeFixed64()
+		case wireBytes:
+			_, err = b.DecodeRawBytes(false)
+		case wireFixed32:
+			_, err = b.DecodeFixed32()
+		default:
+			err = fmt.Errorf("unsupported wire type %d", wireType)
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %v", field, err)
+		}
+	}
+	return nil
+}
+
+// buildBfPipelineConfig builds a BfPipelineConfig from "tofino.bin,context.json[,bf-rt.json]"
+// (a single profile for all pipes) or from a p4c .conf file
+func buildBfPipelineConfig(pipeconfName, deviceConfigPath string) (P4DeviceConfig, error) {
+	var config *BfPipelineConfig
+	var err error
+	if isBfConf(deviceConfigPath) {
+		config, err = loadBfConf(strings.TrimSpace(deviceConfigPath))
+	} else {
+		config, err = loadBfProfile(deviceConfigPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pipeconfName != "" {
+		config.P4Name = pipeconfName
+	} else if config.P4Name == "" {
+		config.P4Name = TOFINO_PIPECONF_NAME
+	}
+	return config.Marshal(), nil
+}
+
+func loadBfProfile(deviceConfigPath string) (*BfPipelineConfig, error) {
+	paths := strings.Split(deviceConfigPath, ",")
+	for i := range paths {
+		paths[i] = strings.TrimSpace(paths[i])
+	}
+	if len(paths) != 2 && len(paths) != 3 ||
+		!strings.HasSuffix(paths[0], ".bin") || !strings.HasSuffix(paths[1], ".json") ||
+		len(paths) == 3 && !strings.HasSuffix(paths[2], ".json") {
+		return nil, fmt.Errorf("Device Config Path is invalid.\n" +
+			"For Tofino BfPipelineConfig targets, provide \"tofino.bin,context.json[,bf-rt.json]\" or a p4c .conf file")
+	}
+
+	fmt.Printf("Tofino Bin: %s\nTofino Context: %s\n", paths[0], paths[1])
+	profile := BfPipelineProfile{ProfileName: "pipe"}
+	var err error
+	if profile.Binary, err = ioutil.ReadFile(paths[0]); err != nil {
+		return nil, err
+	}
+	if profile.Context, err = ioutil.ReadFile(paths[1]); err != nil {
+		return nil, err
+	}
+	config := &BfPipelineConfig{Profiles: []BfPipelineProfile{profile}}
+	if len(paths) == 3 {
+		fmt.Printf("BfRt Info: %s\n", paths[2])
+		if config.BfruntimeInfo, err = ioutil.ReadFile(paths[2]); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// isBfConf returns true if deviceConfigPath is a single p4c .conf file, which names the other files
+func isBfConf(deviceConfigPath string) bool {
+	return !strings.Contains(deviceConfigPath, ",") && strings.HasSuffix(strings.TrimSpace(deviceConfigPath), ".conf")
+}
+
+// bfConf is the part of the .conf file written by p4c (bf-p4c) that describes the pipelines
+type bfConf struct {
+	P4Devices []struct {
+		P4Programs []bfConfProgram `json:"p4_programs"`
+	} `json:"p4_devices"`
+}
+
+type bfConfProgram struct {
+	ProgramName string `json:"program-name"`
+	BfrtConfig  string `json:"bfrt-config"`
+	P4Pipelines []struct {
+		P4PipelineName string   `json:"p4_pipeline_name"`
+		Context        string   `json:"context"`
+		Config         string   `json:"config"`
+		PipeScope      []uint32 `json:"pipe_scope"`
+	} `json:"p4_pipelines"`
+}
+
+// readBfConf parses a p4c .conf file and returns its first program
+func readBfConf(confPath string) (*bfConfProgram, error) {
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return nil, err
+	}
+	var conf bfConf
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", confPath, err)
+	}
+	if len(conf.P4Devices) == 0 || len(conf.P4Devices[0].P4Programs) == 0 {
+		return nil, fmt.Errorf("%s has no P4 program", confPath)
+	}
+	return &conf.P4Devices[0].P4Programs[0], nil
+}
+
+// BundleBfConf prepares a p4c .conf file for a pipeline bundle rooted at dir: it returns a copy of the file
+// in which the paths of the first program (bf-rt.json, and the tofino.bin and context.json of each pipeline)
+// are relative to dir, and those paths. Files outside of dir are an error.
+func BundleBfConf(confPath, dir string) ([]byte, []string, error) {
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conf map[string]interface{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s: %v", confPath, err)
+	}
+	devices, _ := conf["p4_devices"].([]interface{})
+	if len(devices) == 0 {
+		return nil, nil, fmt.Errorf("%s has no P4 program", confPath)
+	}
+	device, _ := devices[0].(map[string]interface{})
+	programs, _ := device["p4_programs"].([]interface{})
+	if len(programs) == 0 {
+		return nil, nil, fmt.Errorf("%s has no P4 program", confPath)
+	}
+	program, _ := programs[0].(map[string]interface{})
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	confDir, err := filepath.Abs(filepath.Dir(confPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []string
+	relativize := func(obj map[string]interface{}, key string) error {
+		name, _ := obj[key].(string)
+		if name == "" {
+			return nil
+		}
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(confDir, path)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s names %s, which is outside of %s", confPath, name, dir)
+		}
+		rel = filepath.ToSlash(rel)
+		obj[key] = rel
+		files = append(files, rel)
+		return nil
+	}
+	if err := relativize(program, "bfrt-config"); err != nil {
+		return nil, nil, err
+	}
+	pipelines, _ := program["p4_pipelines"].([]interface{})
+	for _, p := range pipelines {
+		pipeline, _ := p.(map[string]interface{})
+		if err := relativize(pipeline, "config"); err != nil {
+			return nil, nil, err
+		}
+		if err := relativize(pipeline, "context"); err != nil {
+			return nil, nil, err
+		}
+	}
+	data, err = json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(data, '\n'), files, nil
+}
+
+// loadBfConf reads the first program of a p4c .conf file; relative paths are resolved from the file's directory
+func loadBfConf(confPath string) (*BfPipelineConfig, error) {
+	fmt.Printf("Tofino Conf: %s\n", confPath)
+	program, err := readBfConf(confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(confPath)
+	read := func(path string) ([]byte, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return ioutil.ReadFile(path)
+	}
+	config := &BfPipelineConfig{P4Name: program.ProgramName}
+	if program.BfrtConfig != "" {
+		if config.BfruntimeInfo, err = read(program.BfrtConfig); err != nil {
+			return nil, err
+		}
+	}
+	for _, pipeline := range program.P4Pipelines {
+		profile := BfPipelineProfile{
+			ProfileName: pipeline.P4PipelineName,
+			PipeScope:   pipeline.PipeScope,
+		}
+		if profile.Binary, err = read(pipeline.Config); err != nil {
+			return nil, err
+		}
+		if profile.Context, err = read(pipeline.Context); err != nil {
+			return nil, err
+		}
+		config.Profiles = append(config.Profiles, profile)
+	}
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("%s has no pipelines", confPath)
+	}
+	return config, nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBfPipelineConfigRoundTrip(t *testing.T) {
+	config := &BfPipelineConfig{
+		P4Name:        "p",
+		BfruntimeInfo: []byte("{}"),
+		Profiles: []BfPipelineProfile{
+			{ProfileName: "a", Context: []byte("c"), Binary: []byte("b"), PipeScope: []uint32{0, 2}},
+			{ProfileName: "z", Context: []byte("C"), Binary: []byte("B"), PipeScope: []uint32{1, 3}},
+		},
+	}
+	// Encoded by hand from stratum/hal/lib/barefoot/bf.proto
+	want := []byte{
+		0x0a, 0x01, 'p', // p4_name
+		0x12, 0x02, '{', '}', // bfruntime_info
+		0x1a, 0x0d, // profiles
+		0x0a, 0x01, 'a', // profile_name
+		0x12, 0x01, 'c', // context
+		0x1a, 0x01, 'b', // binary
+		0x22, 0x02, 0x00, 0x02, // packed pipe_scope
+		0x1a, 0x0d,
+		0x0a, 0x01, 'z',
+		0x12, 0x01, 'C',
+		0x1a, 0x01, 'B',
+		0x22, 0x02, 0x01, 0x03,
+	}
+
+	got := config.Marshal()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Marshal() = % x, want % x", got, want)
+	}
+	parsed, err := ParseBfPipelineConfig(got)
+	if err != nil {
+		t.Fatalf("ParseBfPipelineConfig() error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, config) {
+		t.Errorf("ParseBfPipelineConfig() = %+v, want %+v", parsed, config)
+	}
+}
+
+func TestParseBfPipelineConfigUnpackedPipeScope(t *testing.T) {
+	data := []byte{
+		0x0a, 0x01, 'p',
+		0x1a, 0x0a,
+		0x0a, 0x01, 'a',
+		0x20, 0x01, // unpacked pipe_scope
+		0x20, 0x03,
+		0x2a, 0x01, 'x', // unknown field, skipped
+	}
+	parsed, err := ParseBfPipelineConfig(data)
+	if err != nil {
+		t.Fatalf("ParseBfPipelineConfig() error: %v", err)
+	}
+	want := &BfPipelineConfig{
+		P4Name:   "p",
+		Profiles: []BfPipelineProfile{{ProfileName: "a", PipeScope: []uint32{1, 3}}},
+	}
+	if !reflect.DeepEqual(parsed, want) {
+		t.Errorf("ParseBfPipelineConfig() = %+v, want %+v", parsed, want)
+	}
+}
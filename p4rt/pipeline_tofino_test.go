@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTofinoDeviceConfigRoundTrip(t *testing.T) {
+	config := &TofinoDeviceConfig{
+		PipeconfName: "p4rt",
+		Bin:          []byte{0xde, 0xad},
+		Context:      []byte("{}"),
+	}
+	// 32-bit little endian lengths, as expected by Stratum
+	want := []byte{
+		0x04, 0x00, 0x00, 0x00, 'p', '4', 'r', 't',
+		0x02, 0x00, 0x00, 0x00, 0xde, 0xad,
+		0x02, 0x00, 0x00, 0x00, '{', '}',
+	}
+
+	got := config.Pack()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Pack() = % x, want % x", got, want)
+	}
+	parsed, err := ParseTofinoDeviceConfig(got)
+	if err != nil {
+		t.Fatalf("ParseTofinoDeviceConfig() error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, config) {
+		t.Errorf("ParseTofinoDeviceConfig() = %+v, want %+v", parsed, config)
+	}
+	if _, err := ParseTofinoDeviceConfig(got[:len(got)-1]); err == nil {
+		t.Errorf("ParseTofinoDeviceConfig() of a truncated config succeeded")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// PartialReadError is returned when the read stream fails after some entities were received
+type PartialReadError struct {
+	Received int
+	Err      error
+}
+
+func (e *PartialReadError) Error() string {
+	return fmt.Sprintf("read stream failed after %d entities: %v", e.Received, e.Err)
+}
+
+func (e *PartialReadError) Cause() error {
+	return e.Err
+}
+
+// ReadIterator iterates over the entities of a streamed ReadResponse
+type ReadIterator struct {
+	stream       p4.P4Runtime_ReadClient
+	cancel       context.CancelFunc
+	entities     []*p4.Entity
+	received     int
+	err          error
+	canonicalize bool
+}
+
+// Next returns the next entity, or io.EOF when the stream is complete
+func (it *ReadIterator) Next() (*p4.Entity, error) {
+	for len(it.entities) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		res, err := it.stream.Recv()
+		if err == io.EOF {
+			it.err = io.EOF
+			it.cancel()
+		} else if err != nil {
+			if it.received > 0 {
+				it.err = &PartialReadError{Received: it.received, Err: err}
+			} else {
+				it.err = errors.Wrap(err, "error reading entities")
+			}
+			it.cancel()
+		} else {
+			it.entities = res.GetEntities()
+		}
+	}
+	entity := it.entities[0]
+	it.entities = it.entities[1:]
+	it.received++
+	if it.canonicalize {
+		CanonicalizeEntity(entity)
+	}
+	return entity, nil
+}
+
+// ReadAll drains the iterator; on error, the entities received so far are returned with it
+func (it *ReadIterator) ReadAll() ([]*p4.Entity, error) {
+	var entities []*p4.Entity
+	for {
+		entity, err := it.Next()
+		if err == io.EOF {
+			return entities, nil
+		} else if err != nil {
+			return entities, err
+		}
+		entities = append(entities, entity)
+	}
+}
+
+// Close cancels the read stream; it is safe to call after the stream is complete
+func (it *ReadIterator) Close() {
+	it.cancel()
+	if it.err == nil {
+		it.err = io.EOF
+	}
+	it.entities = nil
+}
+
+func (c *p4rtClient) Read(ctx context.Context, entities ...*p4.Entity) *ReadIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &ReadIterator{cancel: cancel, canonicalize: c.canonicalBytestrings}
+	if c.canonicalBytestrings {
+		filters := make([]*p4.Entity, len(entities))
+		for i := range entities {
+			filters[i] = proto.Clone(entities[i]).(*p4.Entity)
+			CanonicalizeEntity(filters[i])
+		}
+		entities = filters
+	}
+	req := &p4.ReadRequest{
+		DeviceId: c.deviceId,
+		Entities: entities,
+	}
+	it.stream, it.err = c.client.Read(ctx, req)
+	if it.err != nil {
+		it.err = errors.Wrap(it.err, "error starting read")
+		cancel()
+	}
+	return it
+}
+
+// ReadTableEntries reads all entries of a table; tableId 0 reads the entries of all tables
+func (c *p4rtClient) ReadTableEntries(ctx context.Context, tableId uint32) ([]*p4.TableEntry, error) {
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_TableEntry{
+		TableEntry: &p4.TableEntry{TableId: tableId},
+	}}).ReadAll()
+	entries := make([]*p4.TableEntry, 0, len(entities))
+	for _, entity := range entities {
+		if entry := entity.GetTableEntry(); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, err
+}
+
+// ReadActionProfileMembers reads all members of an action profile; profileId 0 reads all profiles
+func (c *p4rtClient) ReadActionProfileMembers(ctx context.Context, profileId uint32) ([]*p4.ActionProfileMember, error) {
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_ActionProfileMember{
+		ActionProfileMember: &p4.ActionProfileMember{ActionProfileId: profileId},
+	}}).ReadAll()
+	members := make([]*p4.ActionProfileMember, 0, len(entities))
+	for _, entity := range entities {
+		if member := entity.GetActionProfileMember(); member != nil {
+			members = append(members, member)
+		}
+	}
+	return members, err
+}
+
+// ReadActionProfileGroups reads all groups of an action profile; profileId 0 reads all profiles
+func (c *p4rtClient) ReadActionProfileGroups(ctx context.Context, profileId uint32) ([]*p4.ActionProfileGroup, error) {
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_ActionProfileGroup{
+		ActionProfileGroup: &p4.ActionProfileGroup{ActionProfileId: profileId},
+	}}).ReadAll()
+	groups := make([]*p4.ActionProfileGroup, 0, len(entities))
+	for _, entity := range entities {
+		if group := entity.GetActionProfileGroup(); group != nil {
+			groups = append(groups, group)
+		}
+	}
+	return groups, err
+}
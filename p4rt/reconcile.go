@@ -0,0 +1,138 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReconcileReason is why ReconcileForwardingPipelineConfig pushed the pipeline, or didn't
+type ReconcileReason int
+
+const (
+	// ReconcileUpToDate means the device already runs the desired pipeline; nothing was pushed
+	ReconcileUpToDate ReconcileReason = iota
+	ReconcileForced
+	ReconcileNoPipeline
+	ReconcileCookieMismatch
+	ReconcileP4InfoMismatch
+)
+
+func (r ReconcileReason) String() string {
+	switch r {
+	case ReconcileUpToDate:
+		return "up to date"
+	case ReconcileForced:
+		return "forced"
+	case ReconcileNoPipeline:
+		return "no pipeline on the device"
+	case ReconcileCookieMismatch:
+		return "cookie mismatch"
+	case ReconcileP4InfoMismatch:
+		return "P4Info mismatch"
+	}
+	return fmt.Sprintf("ReconcileReason(%d)", int(r))
+}
+
+// ReconcileResult reports what ReconcileForwardingPipelineConfig decided
+type ReconcileResult struct {
+	Pushed       bool
+	Reason       ReconcileReason
+	DeviceCookie *p4.ForwardingPipelineConfig_Cookie // nil if the device has no pipeline or doesn't report one
+	Cookie       uint64                              // cookie of the desired pipeline
+	Pipeline     *PipelineResult                     // nil if nothing was pushed
+}
+
+func (r ReconcileResult) String() string {
+	action := "kept the pipeline"
+	if r.Pushed {
+		action = fmt.Sprintf("pushed the pipeline with %v", r.Pipeline.Action)
+	}
+	device := "none"
+	if r.DeviceCookie != nil {
+		device = fmt.Sprintf("0x%016x", r.DeviceCookie.GetCookie())
+	}
+	return fmt.Sprintf("%s (%v; cookie 0x%016x, device cookie %s)", action, r.Reason, r.Cookie, device)
+}
+
+// WithForcePush makes ReconcileForwardingPipelineConfig push the pipeline even if the device already runs it
+func WithForcePush() PipelineOption {
+	return func(o *pipelineOptions) {
+		o.force = true
+	}
+}
+
+// ReconcileForwardingPipelineConfig pushes the pipeline (with the action set by WithAction) only if
+// the device doesn't already run it, so that restarting the controller doesn't wipe the forwarding state.
+// The device's config is fetched with P4INFO_AND_COOKIE; the cookies are compared if the
+// device reports one, and then the P4Infos.
+func (c *p4rtClient) ReconcileForwardingPipelineConfig(p4InfoPath, deviceConfigPath string,
+	opts ...PipelineOption) (*ReconcileResult, error) {
+	options := c.pipelineOptions(opts)
+	pipeline, err := loadPipeline(p4InfoPath, deviceConfigPath, options)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := getPipelineConfig(c.client, c.deviceId, p4.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE)
+	if status.Code(errors.Cause(err)) == codes.FailedPrecondition {
+		// the device has no pipeline yet
+		current, err = &p4.ForwardingPipelineConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReconcileResult{
+		Reason:       reconcileReason(pipeline, current),
+		DeviceCookie: current.GetCookie(),
+		Cookie:       pipeline.GetCookie().GetCookie(),
+	}
+	if options.force && result.Reason == ReconcileUpToDate {
+		result.Reason = ReconcileForced
+	}
+	if result.Reason == ReconcileUpToDate {
+		c.p4info = NewP4InfoIndex(pipeline.GetP4Info())
+		return result, nil
+	}
+	result.Pipeline, err = c.pushPipelineConfig(options.action, pipeline)
+	if err != nil {
+		return result, err
+	}
+	result.Pushed = true
+	return result, nil
+}
+
+func reconcileReason(desired, current *p4.ForwardingPipelineConfig) ReconcileReason {
+	if current.GetP4Info() == nil {
+		return ReconcileNoPipeline
+	}
+	// Some targets don't report a cookie, in which case only the P4Info can be compared
+	if current.GetCookie() != nil && current.GetCookie().GetCookie() != desired.GetCookie().GetCookie() {
+		return ReconcileCookieMismatch
+	}
+	if !proto.Equal(current.GetP4Info(), desired.GetP4Info()) {
+		return ReconcileP4InfoMismatch
+	}
+	return ReconcileUpToDate
+}
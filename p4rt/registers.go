@@ -0,0 +1,102 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"context"
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+)
+
+func (c *p4rtClient) findRegister(name string) (*p4_config_v1.Register, error) {
+	index, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	return index.Register(name)
+}
+
+// WriteRegister encodes value according to the register's type_spec (see EncodeP4Data) and writes it at index
+func (c *p4rtClient) WriteRegister(registerName string, index int64, value interface{}) <-chan *p4.Error {
+	register, err := c.findRegister(registerName)
+	if err != nil {
+		return failedWrite(localError(codes.NotFound, "%v", err))
+	}
+	if index < 0 || index >= int64(register.GetSize()) {
+		return failedWrite(localError(codes.OutOfRange, "index %d is out of range for register %s (size %d)",
+			index, registerName, register.GetSize()))
+	}
+	data, err := EncodeP4Data(value, register.GetTypeSpec(), c.p4info.P4Info().GetTypeInfo())
+	if err != nil {
+		return failedWrite(localError(codes.InvalidArgument, "register %s: %v", registerName, err))
+	}
+	return c.Write(&p4.Update{
+		Type: p4.Update_MODIFY,
+		Entity: &p4.Entity{Entity: &p4.Entity_RegisterEntry{RegisterEntry: &p4.RegisterEntry{
+			RegisterId: register.GetPreamble().GetId(),
+			Index:      &p4.Index{Index: index},
+			Data:       data,
+		}}},
+	})
+}
+
+// ReadRegister reads and decodes the value at index of a register (see DecodeP4Data)
+func (c *p4rtClient) ReadRegister(ctx context.Context, registerName string, index int64) (interface{}, error) {
+	values, err := c.readRegisters(ctx, registerName, &p4.Index{Index: index})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[index]
+	if !ok || len(values) != 1 {
+		return nil, fmt.Errorf("expected 1 entry for register %s[%d], got %d", registerName, index, len(values))
+	}
+	return value, nil
+}
+
+// ReadRegisters reads and decodes all indices of a register array
+func (c *p4rtClient) ReadRegisters(ctx context.Context, registerName string) (map[int64]interface{}, error) {
+	return c.readRegisters(ctx, registerName, nil)
+}
+
+func (c *p4rtClient) readRegisters(ctx context.Context, registerName string, index *p4.Index) (map[int64]interface{}, error) {
+	register, err := c.findRegister(registerName)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := c.Read(ctx, &p4.Entity{Entity: &p4.Entity_RegisterEntry{
+		RegisterEntry: &p4.RegisterEntry{RegisterId: register.GetPreamble().GetId(), Index: index},
+	}}).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[int64]interface{}, len(entities))
+	for _, entity := range entities {
+		entry := entity.GetRegisterEntry()
+		if entry == nil {
+			continue
+		}
+		value, err := DecodeP4Data(entry.GetData(), register.GetTypeSpec(), c.p4info.P4Info().GetTypeInfo())
+		if err != nil {
+			return nil, fmt.Errorf("register %s[%d]: %v", registerName, entry.GetIndex().GetIndex(), err)
+		}
+		values[entry.GetIndex().GetIndex()] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+// Number of outgoing PacketOuts and DigestListAcks remembered for correlating stream errors (0 disables it)
+var STREAM_HISTORY_SIZE = 1024
+
+// StreamError is an error reported by the switch on the stream channel for a message we sent.
+// PacketOut or DigestListAck is set according to the type of message that failed; it is empty
+// if the switch did not echo the message. If the echoed message is found among the recently
+// sent messages, it is replaced by the message that was sent and SentAt is set.
+type StreamError struct {
+	Code          codes.Code
+	Message       string
+	Space         string
+	TargetCode    int32 // target-specific code in Space
+	PacketOut     *p4.PacketOut
+	DigestListAck *p4.DigestListAck
+	Other         *any.Any
+	SentAt        time.Time
+}
+
+func (e *StreamError) Error() string {
+	var kind string
+	switch {
+	case e.PacketOut != nil:
+		kind = "packet out"
+	case e.DigestListAck != nil:
+		kind = fmt.Sprintf("digest list ack (digest %d, list %d)", e.DigestListAck.GetDigestId(), e.DigestListAck.GetListId())
+	default:
+		kind = "stream message"
+	}
+	return fmt.Sprintf("%s failed: %v: %s", kind, e.Code, e.Message)
+}
+
+type sentStreamMessage struct {
+	msg    proto.Message
+	sentAt time.Time
+}
+
+// SetStreamErrorChan sets the channel on which stream errors are delivered; if nil, they are printed
+func (c *p4rtClient) SetStreamErrorChan(errorChan chan *StreamError) {
+	c.streamErrorChan = errorChan
+}
+
+// recordSent remembers an outgoing message for correlation; must be called with streamLock held
+func (c *p4rtClient) recordSent(req *p4.StreamMessageRequest) {
+	var msg proto.Message
+	if packet := req.GetPacket(); packet != nil {
+		msg = packet
+	} else if ack := req.GetDigestAck(); ack != nil {
+		msg = ack
+	} else {
+		return
+	}
+	if c.streamHistory == nil {
+		if STREAM_HISTORY_SIZE <= 0 {
+			// history disabled
+			return
+		}
+		c.streamHistory = make([]sentStreamMessage, STREAM_HISTORY_SIZE)
+	}
+	c.streamHistory[c.streamHistoryNext] = sentStreamMessage{msg: msg, sentAt: time.Now()}
+	c.streamHistoryNext = (c.streamHistoryNext + 1) % len(c.streamHistory)
+}
+
+// findSent returns the most recently sent message equal to the echoed one
+func (c *p4rtClient) findSent(echo proto.Message) (proto.Message, time.Time, bool) {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+	for i := 1; i <= len(c.streamHistory); i++ {
+		sent := c.streamHistory[(c.streamHistoryNext-i+len(c.streamHistory))%len(c.streamHistory)]
+		if sent.msg != nil && proto.Equal(sent.msg, echo) {
+			return sent.msg, sent.sentAt, true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+func (c *p4rtClient) handleStreamError(streamError *p4.StreamError) {
+	err := &StreamError{
+		Code:       codes.Code(streamError.GetCanonicalCode()),
+		Message:    streamError.GetMessage(),
+		Space:      streamError.GetSpace(),
+		TargetCode: streamError.GetCode(),
+	}
+	switch details := streamError.GetDetails().(type) {
+	case *p4.StreamError_PacketOut:
+		err.PacketOut = details.PacketOut.GetPacketOut()
+		if err.PacketOut != nil {
+			if sent, sentAt, ok := c.findSent(err.PacketOut); ok {
+				err.PacketOut, err.SentAt = sent.(*p4.PacketOut), sentAt
+			}
+		} else {
+			err.PacketOut = &p4.PacketOut{}
+		}
+	case *p4.StreamError_DigestListAck:
+		err.DigestListAck = details.DigestListAck.GetDigestListAck()
+		if err.DigestListAck != nil {
+			if sent, sentAt, ok := c.findSent(err.DigestListAck); ok {
+				err.DigestListAck, err.SentAt = sent.(*p4.DigestListAck), sentAt
+			}
+		} else {
+			err.DigestListAck = &p4.DigestListAck{}
+		}
+	case *p4.StreamError_Other:
+		err.Other = details.Other.GetOther()
+	}
+
+	if c.streamErrorChan == nil {
+		fmt.Printf("stream error: %v\n", err)
+		return
+	}
+	select {
+	case c.streamErrorChan <- err: // put error into the channel unless it is full
+	default:
+		fmt.Printf("Stream error channel full. Discarding error: %v\n", err)
+	}
+}
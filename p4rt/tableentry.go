@@ -0,0 +1,255 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+)
+
+// TableEntryBuilder builds table entry updates using the names in the P4Info, e.g.
+//
+//	update, err := NewTableEntry(index, "FabricIngress.forwarding.mpls").
+//		Match("mpls_label", 5).
+//		Action("pop_mpls_and_next").Param("next_id", 10).
+//		Insert()
+//
+// Values are integers, *big.Int, []byte, net.IP or net.HardwareAddr (see EncodeExactMatch)
+// and are encoded to the bitwidth of the field.
+// The first error is kept and returned when the update is built.
+type TableEntryBuilder struct {
+	index  *P4InfoIndex
+	table  *p4_config_v1.Table
+	entry  *p4.TableEntry
+	action *p4_config_v1.Action
+	params map[uint32][]byte
+	err    error
+}
+
+func NewTableEntry(index *P4InfoIndex, tableName string) *TableEntryBuilder {
+	b := &TableEntryBuilder{
+		index: index,
+		entry: &p4.TableEntry{},
+	}
+	if index == nil {
+		b.err = fmt.Errorf("no P4Info loaded")
+		return b
+	}
+	b.table, b.err = index.Table(tableName)
+	b.entry.TableId = b.table.GetPreamble().GetId()
+	return b
+}
+
+// Match sets the value of a match field of any kind (see EncodeMatch)
+func (b *TableEntryBuilder) Match(fieldName string, value interface{}) *TableEntryBuilder {
+	mf := b.matchField(fieldName)
+	if mf == nil {
+		return b
+	}
+	fm, err := EncodeMatch(mf, value)
+	return b.setMatch(mf, fm, err)
+}
+
+func (b *TableEntryBuilder) Lpm(fieldName string, value interface{}, prefixLen int32) *TableEntryBuilder {
+	mf := b.matchField(fieldName)
+	if mf == nil {
+		return b
+	}
+	fm, err := EncodeLpmMatch(mf, value, prefixLen)
+	return b.setMatch(mf, fm, err)
+}
+
+func (b *TableEntryBuilder) Ternary(fieldName string, value, mask interface{}) *TableEntryBuilder {
+	mf := b.matchField(fieldName)
+	if mf == nil {
+		return b
+	}
+	fm, err := EncodeTernaryMatch(mf, value, mask)
+	return b.setMatch(mf, fm, err)
+}
+
+func (b *TableEntryBuilder) Range(fieldName string, low, high interface{}) *TableEntryBuilder {
+	mf := b.matchField(fieldName)
+	if mf == nil {
+		return b
+	}
+	fm, err := EncodeRangeMatch(mf, low, high)
+	return b.setMatch(mf, fm, err)
+}
+
+func (b *TableEntryBuilder) matchField(fieldName string) *p4_config_v1.MatchField {
+	if b.err != nil {
+		return nil
+	}
+	for _, mf := range b.table.GetMatchFields() {
+		if mf.GetName() == fieldName {
+			return mf
+		}
+	}
+	b.err = fmt.Errorf("unknown match field %q in table %s", fieldName, b.table.GetPreamble().GetName())
+	return nil
+}
+
+// setMatch replaces the match of the field if it was already set; a nil match ("don't care") removes it
+func (b *TableEntryBuilder) setMatch(mf *p4_config_v1.MatchField, fm *p4.FieldMatch, err error) *TableEntryBuilder {
+	if err != nil {
+		b.err = err
+		return b
+	}
+	for i := range b.entry.Match {
+		if b.entry.Match[i].GetFieldId() == mf.GetId() {
+			if fm == nil {
+				b.entry.Match = append(b.entry.Match[:i], b.entry.Match[i+1:]...)
+			} else {
+				b.entry.Match[i] = fm
+			}
+			return b
+		}
+	}
+	if fm != nil {
+		b.entry.Match = append(b.entry.Match, fm)
+	}
+	return b
+}
+
+// Action sets the action of the entry; it must be one of the table's actions
+func (b *TableEntryBuilder) Action(actionName string) *TableEntryBuilder {
+	if b.err != nil {
+		return b
+	}
+	action, err := b.index.Action(actionName)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	for _, ref := range b.table.GetActionRefs() {
+		if ref.GetId() != action.GetPreamble().GetId() {
+			continue
+		}
+		if ref.GetScope() == p4_config_v1.ActionRef_DEFAULT_ONLY {
+			b.err = fmt.Errorf("action %s can only be the default action of table %s",
+				action.GetPreamble().GetName(), b.table.GetPreamble().GetName())
+			return b
+		}
+		b.action = action
+		b.params = make(map[uint32][]byte)
+		return b
+	}
+	b.err = fmt.Errorf("action %s is not an action of table %s",
+		action.GetPreamble().GetName(), b.table.GetPreamble().GetName())
+	return b
+}
+
+// Param sets a parameter of the action set by Action
+func (b *TableEntryBuilder) Param(paramName string, value interface{}) *TableEntryBuilder {
+	if b.err != nil {
+		return b
+	}
+	if b.action == nil {
+		b.err = fmt.Errorf("param %s set before the action", paramName)
+		return b
+	}
+	for _, p := range b.action.GetParams() {
+		if p.GetName() != paramName {
+			continue
+		}
+		v, err := fieldValue(value, p.GetBitwidth())
+		if err != nil {
+			b.err = fmt.Errorf("param %s: %v", paramName, err)
+			return b
+		}
+		b.params[p.GetId()] = fixedWidthBytes(v, p.GetBitwidth())
+		return b
+	}
+	b.err = fmt.Errorf("unknown param %q in action %s", paramName, b.action.GetPreamble().GetName())
+	return b
+}
+
+func (b *TableEntryBuilder) Priority(priority int32) *TableEntryBuilder {
+	b.entry.Priority = priority
+	return b
+}
+
+// Metadata sets the opaque controller metadata stored with the entry
+func (b *TableEntryBuilder) Metadata(metadata []byte) *TableEntryBuilder {
+	b.entry.Metadata = metadata
+	return b
+}
+
+// Entry returns the table entry with its action, if one was set
+func (b *TableEntryBuilder) Entry() (*p4.TableEntry, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	entry := &p4.TableEntry{
+		TableId:  b.entry.GetTableId(),
+		Match:    append([]*p4.FieldMatch(nil), b.entry.GetMatch()...),
+		Priority: b.entry.GetPriority(),
+		Metadata: b.entry.GetMetadata(),
+	}
+	if b.action != nil {
+		action := &p4.Action{ActionId: b.action.GetPreamble().GetId()}
+		for _, p := range b.action.GetParams() {
+			v, ok := b.params[p.GetId()]
+			if !ok {
+				return nil, fmt.Errorf("missing param %s of action %s", p.GetName(), b.action.GetPreamble().GetName())
+			}
+			action.Params = append(action.Params, &p4.Action_Param{ParamId: p.GetId(), Value: v})
+		}
+		entry.Action = &p4.TableAction{Type: &p4.TableAction_Action{Action: action}}
+	}
+	return entry, nil
+}
+
+func (b *TableEntryBuilder) Insert() (*p4.Update, error) {
+	return b.update(p4.Update_INSERT)
+}
+
+func (b *TableEntryBuilder) Modify() (*p4.Update, error) {
+	return b.update(p4.Update_MODIFY)
+}
+
+// Delete returns a DELETE update with only the key fields (match and priority) of the entry
+func (b *TableEntryBuilder) Delete() (*p4.Update, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &p4.Update{
+		Type: p4.Update_DELETE,
+		Entity: &p4.Entity{Entity: &p4.Entity_TableEntry{TableEntry: &p4.TableEntry{
+			TableId:  b.entry.GetTableId(),
+			Match:    append([]*p4.FieldMatch(nil), b.entry.GetMatch()...),
+			Priority: b.entry.GetPriority(),
+		}}},
+	}, nil
+}
+
+func (b *TableEntryBuilder) update(updateType p4.Update_Type) (*p4.Update, error) {
+	entry, err := b.Entry()
+	if err != nil {
+		return nil, err
+	}
+	if entry.Action == nil {
+		return nil, fmt.Errorf("no action set for %v of table %s", updateType, b.table.GetPreamble().GetName())
+	}
+	return &p4.Update{
+		Type:   updateType,
+		Entity: &p4.Entity{Entity: &p4.Entity_TableEntry{TableEntry: entry}},
+	}, nil
+}
@@ -0,0 +1,444 @@
+/*
+ * Copyright 2020-present Brian O'Connor
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p4rt
+
+import (
+	"fmt"
+	p4_config_v1 "github.com/p4lang/p4runtime/proto/p4/config/v1"
+	p4 "github.com/p4lang/p4runtime/proto/p4/v1"
+	"google.golang.org/grpc/codes"
+	"math/big"
+)
+
+// UpdateValidator checks updates against a P4Info before they are sent to the switch,
+// so that malformed updates fail locally with a descriptive error.
+type UpdateValidator struct {
+	tables         map[uint32]*p4_config_v1.Table
+	actions        map[uint32]*p4_config_v1.Action
+	actionProfiles map[uint32]*p4_config_v1.ActionProfile
+	counters       map[uint32]*p4_config_v1.Counter
+	directCounters map[uint32]*p4_config_v1.DirectCounter
+	meters         map[uint32]*p4_config_v1.Meter
+	directMeters   map[uint32]*p4_config_v1.DirectMeter
+	registers      map[uint32]*p4_config_v1.Register
+	digests        map[uint32]*p4_config_v1.Digest
+}
+
+func NewUpdateValidator(p4info *p4_config_v1.P4Info) *UpdateValidator {
+	v := &UpdateValidator{
+		tables:         make(map[uint32]*p4_config_v1.Table),
+		actions:        make(map[uint32]*p4_config_v1.Action),
+		actionProfiles: make(map[uint32]*p4_config_v1.ActionProfile),
+		counters:       make(map[uint32]*p4_config_v1.Counter),
+		directCounters: make(map[uint32]*p4_config_v1.DirectCounter),
+		meters:         make(map[uint32]*p4_config_v1.Meter),
+		directMeters:   make(map[uint32]*p4_config_v1.DirectMeter),
+		registers:      make(map[uint32]*p4_config_v1.Register),
+		digests:        make(map[uint32]*p4_config_v1.Digest),
+	}
+	for _, t := range p4info.GetTables() {
+		v.tables[t.GetPreamble().GetId()] = t
+	}
+	for _, a := range p4info.GetActions() {
+		v.actions[a.GetPreamble().GetId()] = a
+	}
+	for _, ap := range p4info.GetActionProfiles() {
+		v.actionProfiles[ap.GetPreamble().GetId()] = ap
+	}
+	for _, c := range p4info.GetCounters() {
+		v.counters[c.GetPreamble().GetId()] = c
+	}
+	for _, c := range p4info.GetDirectCounters() {
+		v.directCounters[c.GetPreamble().GetId()] = c
+	}
+	for _, m := range p4info.GetMeters() {
+		v.meters[m.GetPreamble().GetId()] = m
+	}
+	for _, m := range p4info.GetDirectMeters() {
+		v.directMeters[m.GetPreamble().GetId()] = m
+	}
+	for _, r := range p4info.GetRegisters() {
+		v.registers[r.GetPreamble().GetId()] = r
+	}
+	for _, d := range p4info.GetDigests() {
+		v.digests[d.GetPreamble().GetId()] = d
+	}
+	return v
+}
+
+// Validate returns nil if the update is consistent with the P4Info,
+// or an INVALID_ARGUMENT p4.Error describing the first problem found.
+func (v *UpdateValidator) Validate(update *p4.Update) *p4.Error {
+	if err := v.validateUpdate(update); err != nil {
+		return localError(codes.InvalidArgument, "%v", err)
+	}
+	return nil
+}
+
+func (v *UpdateValidator) validateUpdate(update *p4.Update) error {
+	if update.GetType() == p4.Update_UNSPECIFIED {
+		return fmt.Errorf("update type is unspecified")
+	}
+	entity := update.GetEntity()
+	if entity == nil {
+		return fmt.Errorf("update has no entity")
+	}
+	switch e := entity.GetEntity().(type) {
+	case *p4.Entity_TableEntry:
+		return v.validateTableEntry(update.GetType(), e.TableEntry)
+	case *p4.Entity_ActionProfileMember:
+		return v.validateActionProfileMember(update.GetType(), e.ActionProfileMember)
+	case *p4.Entity_ActionProfileGroup:
+		return v.validateActionProfileGroup(e.ActionProfileGroup)
+	case *p4.Entity_CounterEntry:
+		c, ok := v.counters[e.CounterEntry.GetCounterId()]
+		if !ok {
+			return fmt.Errorf("unknown counter id %d", e.CounterEntry.GetCounterId())
+		}
+		return validateIndex(c.GetPreamble().GetName(), e.CounterEntry.GetIndex(), c.GetSize())
+	case *p4.Entity_DirectCounterEntry:
+		return v.validateDirectResource(e.DirectCounterEntry.GetTableEntry(), "direct counter",
+			func(id uint32) bool { _, ok := v.directCounters[id]; return ok })
+	case *p4.Entity_MeterEntry:
+		m, ok := v.meters[e.MeterEntry.GetMeterId()]
+		if !ok {
+			return fmt.Errorf("unknown meter id %d", e.MeterEntry.GetMeterId())
+		}
+		return validateIndex(m.GetPreamble().GetName(), e.MeterEntry.GetIndex(), m.GetSize())
+	case *p4.Entity_DirectMeterEntry:
+		return v.validateDirectResource(e.DirectMeterEntry.GetTableEntry(), "direct meter",
+			func(id uint32) bool { _, ok := v.directMeters[id]; return ok })
+	case *p4.Entity_RegisterEntry:
+		r, ok := v.registers[e.RegisterEntry.GetRegisterId()]
+		if !ok {
+			return fmt.Errorf("unknown register id %d", e.RegisterEntry.GetRegisterId())
+		}
+		return validateIndex(r.GetPreamble().GetName(), e.RegisterEntry.GetIndex(), int64(r.GetSize()))
+	case *p4.Entity_DigestEntry:
+		if _, ok := v.digests[e.DigestEntry.GetDigestId()]; !ok {
+			return fmt.Errorf("unknown digest id %d", e.DigestEntry.GetDigestId())
+		}
+	case nil:
+		return fmt.Errorf("update has an empty entity")
+	}
+	// Other entities (e.g. PRE entries) are not described by the P4Info
+	return nil
+}
+
+func (v *UpdateValidator) validateTableEntry(updateType p4.Update_Type, entry *p4.TableEntry) error {
+	table, ok := v.tables[entry.GetTableId()]
+	if !ok {
+		return fmt.Errorf("unknown table id %d", entry.GetTableId())
+	}
+	tableName := table.GetPreamble().GetName()
+	if table.GetIsConstTable() {
+		return fmt.Errorf("table %s is const and cannot be modified", tableName)
+	}
+
+	if entry.GetIsDefaultAction() {
+		if updateType != p4.Update_MODIFY {
+			return fmt.Errorf("default entry for table %s can only be modified, not %v", tableName, updateType)
+		}
+		if len(entry.GetMatch()) > 0 {
+			return fmt.Errorf("default entry for table %s must not have match fields", tableName)
+		}
+		if table.GetConstDefaultActionId() != 0 {
+			return fmt.Errorf("table %s has a const default action", tableName)
+		}
+		if entry.GetPriority() != 0 {
+			return fmt.Errorf("default entry for table %s must not have a priority", tableName)
+		}
+		return v.validateTableAction(updateType, table, entry.GetAction(), true)
+	}
+
+	fields := make(map[uint32]*p4_config_v1.MatchField)
+	needsPriority := false
+	for _, mf := range table.GetMatchFields() {
+		fields[mf.GetId()] = mf
+		switch mf.GetMatchType() {
+		case p4_config_v1.MatchField_TERNARY, p4_config_v1.MatchField_RANGE, p4_config_v1.MatchField_OPTIONAL:
+			needsPriority = true
+		}
+	}
+
+	seen := make(map[uint32]bool)
+	for _, fm := range entry.GetMatch() {
+		mf, ok := fields[fm.GetFieldId()]
+		if !ok {
+			return fmt.Errorf("unknown match field id %d for table %s", fm.GetFieldId(), tableName)
+		}
+		if seen[fm.GetFieldId()] {
+			return fmt.Errorf("duplicate match field %s for table %s", mf.GetName(), tableName)
+		}
+		seen[fm.GetFieldId()] = true
+		if err := validateFieldMatch(mf, fm); err != nil {
+			return fmt.Errorf("match field %s for table %s: %v", mf.GetName(), tableName, err)
+		}
+	}
+	for _, mf := range table.GetMatchFields() {
+		if mf.GetMatchType() == p4_config_v1.MatchField_EXACT && !seen[mf.GetId()] {
+			return fmt.Errorf("missing exact match field %s for table %s", mf.GetName(), tableName)
+		}
+	}
+
+	if needsPriority && entry.GetPriority() <= 0 {
+		return fmt.Errorf("table %s has ternary, range or optional match fields; priority must be > 0", tableName)
+	} else if !needsPriority && entry.GetPriority() != 0 {
+		return fmt.Errorf("table %s has no ternary, range or optional match fields; priority must be 0", tableName)
+	}
+
+	return v.validateTableAction(updateType, table, entry.GetAction(), false)
+}
+
+func (v *UpdateValidator) validateTableAction(updateType p4.Update_Type, table *p4_config_v1.Table,
+	tableAction *p4.TableAction, isDefault bool) error {
+	tableName := table.GetPreamble().GetName()
+	if tableAction == nil {
+		if updateType == p4.Update_DELETE || isDefault {
+			// delete only needs the match key; a default entry without an action resets the default
+			return nil
+		}
+		return fmt.Errorf("missing action for table %s", tableName)
+	}
+
+	switch a := tableAction.GetType().(type) {
+	case *p4.TableAction_Action:
+		var ref *p4_config_v1.ActionRef
+		for _, r := range table.GetActionRefs() {
+			if r.GetId() == a.Action.GetActionId() {
+				ref = r
+				break
+			}
+		}
+		if ref == nil {
+			return fmt.Errorf("action id %d is not valid for table %s", a.Action.GetActionId(), tableName)
+		}
+		if isDefault && ref.GetScope() == p4_config_v1.ActionRef_TABLE_ONLY {
+			return fmt.Errorf("action id %d cannot be the default action for table %s", ref.GetId(), tableName)
+		} else if !isDefault && ref.GetScope() == p4_config_v1.ActionRef_DEFAULT_ONLY {
+			return fmt.Errorf("action id %d can only be the default action for table %s", ref.GetId(), tableName)
+		}
+		return v.validateAction(a.Action)
+	case *p4.TableAction_ActionProfileMemberId, *p4.TableAction_ActionProfileGroupId,
+		*p4.TableAction_ActionProfileActionSet:
+		if table.GetImplementationId() == 0 {
+			return fmt.Errorf("table %s has no action profile implementation", tableName)
+		}
+		if set := tableAction.GetActionProfileActionSet(); set != nil {
+			for _, pa := range set.GetActionProfileActions() {
+				if err := v.validateAction(pa.GetAction()); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("empty action for table %s", tableName)
+	}
+	return nil
+}
+
+func (v *UpdateValidator) validateAction(action *p4.Action) error {
+	info, ok := v.actions[action.GetActionId()]
+	if !ok {
+		return fmt.Errorf("unknown action id %d", action.GetActionId())
+	}
+	actionName := info.GetPreamble().GetName()
+
+	params := make(map[uint32]*p4_config_v1.Action_Param)
+	for _, p := range info.GetParams() {
+		params[p.GetId()] = p
+	}
+	seen := make(map[uint32]bool)
+	for _, p := range action.GetParams() {
+		param, ok := params[p.GetParamId()]
+		if !ok {
+			return fmt.Errorf("unknown param id %d for action %s", p.GetParamId(), actionName)
+		}
+		if seen[p.GetParamId()] {
+			return fmt.Errorf("duplicate param %s for action %s", param.GetName(), actionName)
+		}
+		seen[p.GetParamId()] = true
+		if err := validateBytes(p.GetValue(), param.GetBitwidth()); err != nil {
+			return fmt.Errorf("param %s for action %s: %v", param.GetName(), actionName, err)
+		}
+	}
+	for _, p := range info.GetParams() {
+		if !seen[p.GetId()] {
+			return fmt.Errorf("missing param %s for action %s", p.GetName(), actionName)
+		}
+	}
+	return nil
+}
+
+func (v *UpdateValidator) validateActionProfileMember(updateType p4.Update_Type, member *p4.ActionProfileMember) error {
+	profile, ok := v.actionProfiles[member.GetActionProfileId()]
+	if !ok {
+		return fmt.Errorf("unknown action profile id %d", member.GetActionProfileId())
+	}
+	if member.GetAction() == nil {
+		if updateType == p4.Update_DELETE {
+			return nil
+		}
+		return fmt.Errorf("missing action for member %d of action profile %s",
+			member.GetMemberId(), profile.GetPreamble().GetName())
+	}
+	return v.validateAction(member.GetAction())
+}
+
+func (v *UpdateValidator) validateActionProfileGroup(group *p4.ActionProfileGroup) error {
+	profile, ok := v.actionProfiles[group.GetActionProfileId()]
+	if !ok {
+		return fmt.Errorf("unknown action profile id %d", group.GetActionProfileId())
+	}
+	profileName := profile.GetPreamble().GetName()
+	if !profile.GetWithSelector() {
+		return fmt.Errorf("action profile %s has no selector and does not support groups", profileName)
+	}
+	if max := profile.GetMaxGroupSize(); max > 0 && len(group.GetMembers()) > int(max) {
+		return fmt.Errorf("group %d has %d members; action profile %s allows at most %d",
+			group.GetGroupId(), len(group.GetMembers()), profileName, max)
+	}
+	return nil
+}
+
+func (v *UpdateValidator) validateDirectResource(entry *p4.TableEntry, kind string, isKind func(id uint32) bool) error {
+	if entry == nil {
+		return fmt.Errorf("%s entry has no table entry", kind)
+	}
+	table, ok := v.tables[entry.GetTableId()]
+	if !ok {
+		return fmt.Errorf("unknown table id %d for %s", entry.GetTableId(), kind)
+	}
+	for _, id := range table.GetDirectResourceIds() {
+		if isKind(id) {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %s has no %s", table.GetPreamble().GetName(), kind)
+}
+
+func validateIndex(name string, index *p4.Index, size int64) error {
+	if index == nil {
+		// wildcard (e.g. reset all entries)
+		return nil
+	}
+	if index.GetIndex() < 0 || index.GetIndex() >= size {
+		return fmt.Errorf("index %d is out of range for %s (size %d)", index.GetIndex(), name, size)
+	}
+	return nil
+}
+
+func validateFieldMatch(mf *p4_config_v1.MatchField, fm *p4.FieldMatch) error {
+	bitwidth := mf.GetBitwidth()
+	expected := mf.GetMatchType()
+	switch m := fm.GetFieldMatchType().(type) {
+	case *p4.FieldMatch_Exact_:
+		if expected != p4_config_v1.MatchField_EXACT {
+			return fmt.Errorf("got exact match, expected %v", expected)
+		}
+		return validateBytes(m.Exact.GetValue(), bitwidth)
+	case *p4.FieldMatch_Lpm:
+		if expected != p4_config_v1.MatchField_LPM {
+			return fmt.Errorf("got LPM match, expected %v", expected)
+		}
+		if m.Lpm.GetPrefixLen() <= 0 || m.Lpm.GetPrefixLen() > bitwidth {
+			return fmt.Errorf("prefix length %d is out of range (1-%d)", m.Lpm.GetPrefixLen(), bitwidth)
+		}
+		if err := validateBytes(m.Lpm.GetValue(), bitwidth); err != nil {
+			return err
+		}
+		value := new(big.Int).SetBytes(m.Lpm.GetValue())
+		if new(big.Int).AndNot(value, prefixMask(m.Lpm.GetPrefixLen(), bitwidth)).Sign() != 0 {
+			return fmt.Errorf("value 0x%x has bits set beyond prefix length %d", m.Lpm.GetValue(), m.Lpm.GetPrefixLen())
+		}
+	case *p4.FieldMatch_Ternary_:
+		if expected != p4_config_v1.MatchField_TERNARY {
+			return fmt.Errorf("got ternary match, expected %v", expected)
+		}
+		if err := validateBytes(m.Ternary.GetValue(), bitwidth); err != nil {
+			return fmt.Errorf("value: %v", err)
+		}
+		if err := validateBytes(m.Ternary.GetMask(), bitwidth); err != nil {
+			return fmt.Errorf("mask: %v", err)
+		}
+		value := new(big.Int).SetBytes(m.Ternary.GetValue())
+		mask := new(big.Int).SetBytes(m.Ternary.GetMask())
+		if mask.Sign() == 0 {
+			return fmt.Errorf("mask is zero; omit don't-care fields from the match")
+		}
+		if new(big.Int).AndNot(value, mask).Sign() != 0 {
+			return fmt.Errorf("value 0x%x has bits set outside of mask 0x%x", m.Ternary.GetValue(), m.Ternary.GetMask())
+		}
+	case *p4.FieldMatch_Range_:
+		if expected != p4_config_v1.MatchField_RANGE {
+			return fmt.Errorf("got range match, expected %v", expected)
+		}
+		if err := validateBytes(m.Range.GetLow(), bitwidth); err != nil {
+			return fmt.Errorf("low: %v", err)
+		}
+		if err := validateBytes(m.Range.GetHigh(), bitwidth); err != nil {
+			return fmt.Errorf("high: %v", err)
+		}
+		low := new(big.Int).SetBytes(m.Range.GetLow())
+		high := new(big.Int).SetBytes(m.Range.GetHigh())
+		if low.Cmp(high) > 0 {
+			return fmt.Errorf("low 0x%x is greater than high 0x%x", m.Range.GetLow(), m.Range.GetHigh())
+		}
+	case *p4.FieldMatch_Optional_:
+		if expected != p4_config_v1.MatchField_OPTIONAL {
+			return fmt.Errorf("got optional match, expected %v", expected)
+		}
+		return validateBytes(m.Optional.GetValue(), bitwidth)
+	case *p4.FieldMatch_Other:
+		if mf.GetOtherMatchType() == "" {
+			return fmt.Errorf("got other match, expected %v", expected)
+		}
+	default:
+		return fmt.Errorf("empty match")
+	}
+	return nil
+}
+
+// validateBytes checks that value is a non-empty bytestring whose value fits in bitwidth bits
+func validateBytes(value []byte, bitwidth int32) error {
+	if len(value) == 0 {
+		return fmt.Errorf("value is empty")
+	}
+	if bitwidth <= 0 {
+		// bitwidth unknown (e.g. user-defined type with string translation)
+		return nil
+	}
+	if bits := bitLen(value); bits > int(bitwidth) {
+		return fmt.Errorf("value 0x%x needs %d bits; bitwidth is %d", value, bits, bitwidth)
+	}
+	return nil
+}
+
+// bitLen returns the number of significant bits in a big-endian bytestring
+func bitLen(value []byte) int {
+	for i, b := range value {
+		if b != 0 {
+			bits := (len(value) - i - 1) * 8
+			for ; b != 0; b >>= 1 {
+				bits++
+			}
+			return bits
+		}
+	}
+	return 0
+}
@@ -44,18 +44,49 @@ type WriteTrace struct {
 }
 
 func (c *p4rtClient) Write(update *p4.Update) <-chan *p4.Error {
+	if c.validator != nil {
+		if err := c.validator.Validate(update); err != nil {
+			// fail the update locally; it is never sent to the switch
+			return failedWrite(err)
+		}
+	}
+	update = proto.Clone(update).(*p4.Update)
+	if c.canonicalBytestrings {
+		CanonicalizeEntity(update.GetEntity())
+	}
 	res := make(chan *p4.Error, 1)
 	c.writes <- p4Write{
-		update:   proto.Clone(update).(*p4.Update),
+		update:   update,
 		response: res,
 	}
 	return res
 }
 
+// failedWrite returns a response channel for an update that was rejected before being sent
+func failedWrite(err *p4.Error) <-chan *p4.Error {
+	res := make(chan *p4.Error, 1)
+	res <- err
+	return res
+}
+
+// localError builds a p4.Error for problems detected by the client rather than the switch
+func localError(code codes.Code, format string, args ...interface{}) *p4.Error {
+	return &p4.Error{
+		CanonicalCode: int32(code),
+		Message:       fmt.Sprintf(format, args...),
+		Space:         "p4rt-go",
+	}
+}
+
 func (c *p4rtClient) SetWriteTraceChan(traceChan chan WriteTrace) {
 	c.writeTraceChan = traceChan
 }
 
+// SetUpdateValidator enables pre-send validation of all writes; nil disables it
+func (c *p4rtClient) SetUpdateValidator(validator *UpdateValidator) {
+	c.validator = validator
+}
+
 func (c *p4rtClient) ListenForWrites() {
 	for {
 		writes := make([]p4Write, MAX_BATCH_SIZE)